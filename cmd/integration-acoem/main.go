@@ -2,34 +2,106 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/diwise/context-broker/pkg/ngsild/client"
 	"github.com/diwise/service-chassis/pkg/infrastructure/buildinfo"
 	"github.com/diwise/service-chassis/pkg/infrastructure/env"
+	"github.com/diwise/service-chassis/pkg/infrastructure/net/http/handlers"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 
+	"github.com/diwise/integration-acoem/domain"
 	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"github.com/diwise/integration-acoem/internal/pkg/application/calibration"
+	"github.com/diwise/integration-acoem/internal/pkg/application/checkpoint"
 	"github.com/diwise/integration-acoem/internal/pkg/application/fiware"
 	"github.com/diwise/integration-acoem/internal/pkg/application/lwm2m"
+	"github.com/diwise/integration-acoem/internal/pkg/application/outlier"
+	"github.com/diwise/integration-acoem/internal/pkg/application/quality"
+	"github.com/diwise/integration-acoem/internal/pkg/application/scheduler"
+	"github.com/diwise/integration-acoem/internal/pkg/infrastructure/metrics"
+	"github.com/diwise/integration-acoem/internal/pkg/presentation/api"
 )
 
 const (
 	serviceName      string = "integration-acoem"
 	OutputTypeLwm2m  string = "lwm2m"
 	OutputTypeFiware string = "fiware"
+	ModeOnce         string = "once"
+	ModeDaemon       string = "daemon"
+	ModeBackfill     string = "backfill"
+)
+
+const (
+	exitOK                int = 0
+	exitFailure           int = 1
+	exitConfigError       int = 2
+	exitUnauthorized      int = 3
+	exitAllDevicesFailed  int = 4
+	exitSomeDevicesFailed int = 5
 )
 
 func main() {
 	serviceVersion := buildinfo.SourceVersion()
 
-	ctx, logger, cleanup := o11y.Init(context.Background(), serviceName, serviceVersion, "json")
+	ctx, logger, cleanupO11y := o11y.Init(context.Background(), serviceName, serviceVersion, "json")
+
+	cleanupMetrics, err := metrics.Init(ctx, serviceName, serviceVersion)
+	if err != nil {
+		logger.Error("failed to init metrics", "err", err.Error())
+		cleanupO11y()
+		os.Exit(exitFailure)
+	}
+
+	cleanup := func() {
+		cleanupMetrics()
+		cleanupO11y()
+	}
 	defer cleanup()
 
-	var outputType string
+	var outputList, mode, from, to, deviceList, average, dataType, mappingFile, rejectFlags, annotateFlags, readingName, calibrationFile, outlierActionName, checkpointFile string
+	var numberOfRecords, rateBurst, stuckReadings int
+	var rateLimit, minValidPercentage float64
+	var interval, delay, chunk, maxCatchUp, deviceTimeout, setupCacheTTL time.Duration
+	var concurrency int
 
-	flag.StringVar(&outputType, "output", OutputTypeFiware, "-output=<lwm2m or fiware>")
+	flag.StringVar(&outputList, "output", OutputTypeFiware, "-output=<comma separated list of outputs to deliver to, lwm2m and/or fiware>")
+	flag.StringVar(&mode, "mode", ModeOnce, "-mode=<once, daemon or backfill>")
+	flag.DurationVar(&interval, "interval", 5*time.Minute, "-interval=<polling interval in daemon mode, defaults to the averaging period>")
+	flag.DurationVar(&delay, "delay", 30*time.Second, "-delay=<time to wait after each interval boundary before polling>")
+	flag.StringVar(&from, "from", "", "-from=<start of backfill range in RFC3339 format>")
+	flag.StringVar(&to, "to", "", "-to=<end of backfill range in RFC3339 format, defaults to now>")
+	flag.StringVar(&deviceList, "devices", "", "-devices=<comma separated list of device IDs to backfill, defaults to all>")
+	flag.DurationVar(&chunk, "chunk", 24*time.Hour, "-chunk=<length of each time range requested from acoem when backfilling or catching up since a checkpoint>")
+	flag.DurationVar(&maxCatchUp, "max-catchup", 24*time.Hour, "-max-catchup=<how far back to retrieve data for devices with an old checkpoint>")
+	flag.StringVar(&average, "average", env.GetVariableOrDefault(ctx, "ACOEM_AVERAGE", "AVG300"), "-average=<averaging period, AVG followed by one of 0, 300, 600, 900, 1200, 1800, 3600, 7200, 10800, 14400, 21600, 28800, 43200 or 86400 seconds>")
+	flag.IntVar(&numberOfRecords, "records", intFromEnv(ctx, "ACOEM_NUMBER_OF_RECORDS", 1), "-records=<number of latest records to retrieve per device without a checkpoint, devices with a checkpoint get all data since then>")
+	flag.StringVar(&dataType, "type", env.GetVariableOrDefault(ctx, "ACOEM_DATA_TYPE", application.DataTypeData), "-type=<data, diagnostic or datadiagnostic>")
+	flag.IntVar(&concurrency, "concurrency", 4, "-concurrency=<number of devices to process in parallel>")
+	flag.DurationVar(&deviceTimeout, "device-timeout", 2*time.Minute, "-device-timeout=<maximum time spent on a single device per poll>")
+	flag.Float64Var(&rateLimit, "rate-limit", floatFromEnv(ctx, "ACOEM_RATE_LIMIT", 0), "-rate-limit=<max requests per second to acoem, 0 means unlimited>")
+	flag.IntVar(&rateBurst, "rate-burst", intFromEnv(ctx, "ACOEM_RATE_BURST", 1), "-rate-burst=<number of requests to acoem allowed in a burst>")
+	flag.DurationVar(&setupCacheTTL, "setup-cache-ttl", time.Hour, "-setup-cache-ttl=<how long device setups are cached, 0 disables caching>")
+	flag.Float64Var(&minValidPercentage, "min-valid-percentage", floatFromEnv(ctx, "QUALITY_MIN_VALID_PERCENTAGE", 0), "-min-valid-percentage=<channels with a lower valid percentage are not published>")
+	flag.StringVar(&rejectFlags, "reject-flags", env.GetVariableOrDefault(ctx, "QUALITY_REJECT_FLAGS", ""), "-reject-flags=<comma separated list of acoem flags that cause a channel not to be published>")
+	flag.StringVar(&annotateFlags, "annotate-flags", env.GetVariableOrDefault(ctx, "QUALITY_ANNOTATE_FLAGS", ""), "-annotate-flags=<comma separated list of acoem flags that are published along with the value of a channel>")
+	flag.StringVar(&readingName, "reading", env.GetVariableOrDefault(ctx, "ACOEM_READING", string(domain.ReadingScaled)), "-reading=<reading to publish, scaled, prescaled or both>")
+	flag.StringVar(&calibrationFile, "calibration", env.GetVariableOrDefault(ctx, "CALIBRATION_FILE", ""), "-calibration=<yaml or json file with local calibrations of device sensors>")
+	flag.StringVar(&outlierActionName, "outlier-action", env.GetVariableOrDefault(ctx, "OUTLIER_ACTION", string(outlier.ActionFlag)), "-outlier-action=<what to do with outliers, flag, annotate or drop>")
+	flag.IntVar(&stuckReadings, "stuck-readings", intFromEnv(ctx, "OUTLIER_STUCK_READINGS", 0), "-stuck-readings=<number of identical consecutive readings after which a sensor is considered stuck, 0 disables the check>")
+	flag.StringVar(&checkpointFile, "checkpoints", env.GetVariableOrDefault(ctx, "CHECKPOINT_FILE", "data/checkpoints.json"), "-checkpoints=<file where the last delivered data per device and output is stored, should be on a persistent volume>")
+	flag.StringVar(&mappingFile, "mapping", env.GetVariableOrDefault(ctx, "SENSOR_MAPPING_FILE", ""), "-mapping=<yaml or json file with sensor mappings that extend or replace the built-in ones>")
 	flag.Parse()
 
 	baseUrl := env.GetVariableOrDie(ctx, "ACOEM_BASEURL", "acoem base url")
@@ -37,50 +109,369 @@ func main() {
 	accountKey := env.GetVariableOrDie(ctx, "ACOEM_ACCOUNT_KEY", "acoem account key")
 	cipUrl := env.GetVariableOrDefault(ctx, "CONTEXT_BROKER_URL", "")
 	lwm2mUrl := env.GetVariableOrDefault(ctx, "LWM2M_ENDPOINT_URL", "")
+	servicePort := env.GetVariableOrDefault(ctx, "SERVICE_PORT", "8080")
+
+	if mode != ModeOnce && mode != ModeDaemon && mode != ModeBackfill {
+		logger.Error("unknown mode, expected once, daemon or backfill", "mode", mode)
+		os.Exit(exitConfigError)
+	}
+
+	reading, err := domain.ParseReadingSelection(readingName)
+	if err != nil {
+		logger.Error("invalid reading selection", "err", err.Error())
+		os.Exit(exitConfigError)
+	}
+
+	outlierAction, err := outlier.ParseAction(outlierActionName)
+	if err != nil {
+		logger.Error("invalid outlier action", "err", err.Error())
+		os.Exit(exitConfigError)
+	}
+
+	catalogue, err := loadCatalogue(mappingFile)
+	if err != nil {
+		logger.Error("invalid sensor mapping", "file", mappingFile, "err", err.Error())
+		os.Exit(exitConfigError)
+	}
+
+	calibrations, err := loadCalibrations(calibrationFile)
+	if err != nil {
+		logger.Error("invalid calibrations", "file", calibrationFile, "err", err.Error())
+		os.Exit(exitConfigError)
+	}
+
+	registry := map[string]sinkFactory{
+		OutputTypeFiware: func() (application.Sink, error) { return newFiwareSink(cipUrl, catalogue, reading) },
+		OutputTypeLwm2m:  func() (application.Sink, error) { return newLwm2mSink(lwm2mUrl, catalogue, reading) },
+	}
+
+	sinks, err := newSinks(ctx, outputList, registry)
+	if err != nil {
+		logger.Error("invalid output configuration", "err", err.Error())
+		os.Exit(exitConfigError)
+	}
+
+	checkpoints, err := checkpoint.NewFileStore(checkpointFile)
+	if err != nil {
+		logger.Error("failed to load checkpoints", "file", checkpointFile, "err", err.Error())
+		os.Exit(exitConfigError)
+	}
+
+	a, err := application.New(baseUrl, accountID, accountKey,
+		application.WithAveragingPeriod(average),
+		application.WithNumberOfRecords(numberOfRecords),
+		application.WithDataType(dataType),
+		application.WithRateLimit(rateLimit, rateBurst),
+		application.WithSetupCacheTTL(setupCacheTTL),
+	)
+	if err != nil {
+		logger.Error("invalid acoem configuration", "err", err.Error())
+		os.Exit(exitConfigError)
+	}
 
-	if outputType == OutputTypeFiware {
-		if cipUrl == "" {
-			logger.Error("no URL to context broker specified using env. var CONTEXT_BROKER_URL")
-			os.Exit(1)
+	if !isFlagSet("interval") {
+		// a zero averaging period means raw data, which is left to the default interval
+		if period, _ := application.ParseAveragingPeriod(average); period > 0 {
+			interval = period
 		}
 	}
 
-	if outputType == OutputTypeLwm2m {
-		if lwm2mUrl == "" {
-			logger.Error("no URL to lwm2m endpoint specified using env. var LWM2M_ENDPOINT_URL")
-			os.Exit(1)
+	if minValidPercentage < 0 || minValidPercentage > 100 {
+		logger.Error("minimum valid percentage must be between 0 and 100", "min_valid_percentage", minValidPercentage)
+		os.Exit(exitConfigError)
+	}
+
+	if stuckReadings < 0 {
+		logger.Error("number of stuck readings must not be negative", "stuck_readings", stuckReadings)
+		os.Exit(exitConfigError)
+	}
+
+	policy := quality.Policy{
+		MinValidPercentage: minValidPercentage,
+		RejectFlags:        splitList(rejectFlags),
+		AnnotateFlags:      splitList(annotateFlags),
+	}
+
+	s, err := scheduler.New(a, sinks,
+		scheduler.WithInterval(interval),
+		scheduler.WithDelay(delay),
+		scheduler.WithChunkSize(chunk),
+		scheduler.WithCheckpoints(checkpoints),
+		scheduler.WithMaxCatchUp(maxCatchUp),
+		scheduler.WithConcurrency(concurrency),
+		scheduler.WithDeviceTimeout(deviceTimeout),
+		scheduler.WithProcessors(
+			quality.NewProcessor(policy),
+			calibration.NewProcessor(calibrations, catalogue, reading),
+			outlier.NewProcessor(catalogue, reading, outlier.Config{Action: outlierAction, StuckReadings: stuckReadings}),
+		),
+	)
+	if err != nil {
+		logger.Error("invalid scheduler configuration", "err", err.Error())
+		os.Exit(exitConfigError)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var result scheduler.Result
+
+	switch mode {
+	case ModeDaemon:
+		probes := map[string]handlers.ServiceProber{
+			"acoem": func(context.Context) (string, error) {
+				return "", s.Status().AcoemReachable()
+			},
+		}
+
+		mux := http.NewServeMux()
+		api.RegisterHandlers(ctx, mux, s.Status, probes)
+
+		shutdown := startServer(ctx, ":"+servicePort, mux)
+		err = s.Run(ctx)
+		shutdown()
+	case ModeBackfill:
+		var start, end time.Time
+		var deviceIDs []int
+		start, end, deviceIDs, err = parseBackfillArgs(from, to, deviceList)
+		if err != nil {
+			logger.Error("invalid backfill arguments", "err", err.Error())
+			os.Exit(exitConfigError)
 		}
+		result, err = s.Backfill(ctx, start, end, deviceIDs)
+	default:
+		result, err = s.RunOnce(ctx)
 	}
 
-	a := application.New(baseUrl, accountID, accountKey)
+	closeSinks(ctx, sinks)
+
+	if mode != ModeDaemon {
+		logger.Info("run summary", "fetched", result.Fetched, "delivered", result.Delivered, "failed", result.Failed, "skipped", result.Skipped)
+	}
 
-	devices, err := a.GetDevices(ctx)
 	if err != nil {
-		logger.Error("failed to retrieve devices", "err", err.Error())
+		logger.Error("integration failed", "err", err.Error())
+
+		code := exitCode(err)
+		stop()
+		cleanup()
+		os.Exit(code)
+	}
+}
+
+// exitCode maps an error to a process exit code, so that job monitoring can tell
+// a rejected account from an outage or a partial failure. The errors of failed devices
+// are classified by how many devices failed, regardless of why each of them failed.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, scheduler.ErrAllDevicesFailed):
+		return exitAllDevicesFailed
+	case errors.Is(err, scheduler.ErrSomeDevicesFailed):
+		return exitSomeDevicesFailed
+	case errors.Is(err, application.ErrUnauthorized):
+		return exitUnauthorized
 	}
+	return exitFailure
+}
+
+// sinkFactory creates a sink, failing if the sink is not properly configured
+type sinkFactory func() (application.Sink, error)
+
+// newSinks creates a sink for each output in the comma separated list, using the registry
+// of supported outputs
+func newSinks(ctx context.Context, outputList string, registry map[string]sinkFactory) ([]application.Sink, error) {
+	sinks := []application.Sink{}
+
+	for _, name := range strings.Split(outputList, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || slices.ContainsFunc(sinks, func(s application.Sink) bool { return s.Name() == name }) {
+			continue
+		}
 
-	contextBroker := client.NewContextBrokerClient(cipUrl)
+		factory, ok := registry[name]
+		if !ok {
+			closeSinks(ctx, sinks)
+			return nil, fmt.Errorf("unknown output %q", name)
+		}
 
-	for _, d := range devices {
-		sensorLabels, err := a.GetSensorLabels(ctx, d.UniqueId)
+		sink, err := factory()
 		if err != nil {
-			logger.Error("failed to retrieve sensor labels for device", "device_id", d.UniqueId, "err", err.Error())
+			closeSinks(ctx, sinks)
+			return nil, fmt.Errorf("failed to create output %s: %w", name, err)
 		}
 
-		logger.Info("retrieving data", "sensor_labels", sensorLabels, "device_id", d.UniqueId)
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("at least one output must be specified using -output")
+	}
+
+	return sinks, nil
+}
 
-		sensors, err := a.GetDeviceData(ctx, d.UniqueId, sensorLabels)
+func closeSinks(ctx context.Context, sinks []application.Sink) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			logging.GetFromContext(ctx).Error("failed to close sink", "sink", sink.Name(), "err", err.Error())
+		}
+	}
+}
+
+// loadCatalogue returns the built-in catalogue, merged with the mappings in file if set
+func loadCatalogue(file string) (*domain.Catalogue, error) {
+	if file == "" {
+		return domain.DefaultCatalogue(), nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return domain.LoadCatalogue(f)
+}
+
+// loadCalibrations returns the calibrations in file, or no calibrations if file is not set
+func loadCalibrations(file string) (calibration.Table, error) {
+	if file == "" {
+		return calibration.Table{}, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return calibration.Table{}, err
+	}
+	defer f.Close()
+
+	return calibration.Load(f)
+}
+
+func newFiwareSink(cipUrl string, catalogue *domain.Catalogue, reading domain.ReadingSelection) (application.Sink, error) {
+	if cipUrl == "" {
+		return nil, fmt.Errorf("no URL to context broker specified using env. var CONTEXT_BROKER_URL")
+	}
+
+	return fiware.NewSink(client.NewContextBrokerClient(cipUrl), catalogue, reading), nil
+}
+
+func newLwm2mSink(lwm2mUrl string, catalogue *domain.Catalogue, reading domain.ReadingSelection) (application.Sink, error) {
+	if lwm2mUrl == "" {
+		return nil, fmt.Errorf("no URL to lwm2m endpoint specified using env. var LWM2M_ENDPOINT_URL")
+	}
+
+	return lwm2m.NewSink(lwm2mUrl, catalogue, reading), nil
+}
+
+// startServer serves handler on addr in the background and returns a function that
+// shuts the server down, waiting a short while for ongoing requests to complete
+func startServer(ctx context.Context, addr string, handler http.Handler) func() {
+	logger := logging.GetFromContext(ctx)
+
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	go func() {
+		logger.Info("starting http server", "addr", addr)
+
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server failed", "err", err.Error())
+		}
+	}()
+
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("failed to shut down http server", "err", err.Error())
+		}
+	}
+}
+
+func parseBackfillArgs(from, to, deviceList string) (time.Time, time.Time, []int, error) {
+	if from == "" {
+		return time.Time{}, time.Time{}, nil, fmt.Errorf("a start time must be specified using -from")
+	}
+
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, nil, fmt.Errorf("failed to parse start time: %w", err)
+	}
+
+	end := time.Now().UTC()
+	if to != "" {
+		end, err = time.Parse(time.RFC3339, to)
 		if err != nil {
-			logger.Error("failed to retrieve sensor data", "err", err.Error())
+			return time.Time{}, time.Time{}, nil, fmt.Errorf("failed to parse end time: %w", err)
+		}
+	}
+
+	deviceIDs := []int{}
+
+	for _, id := range strings.Split(deviceList, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
 		}
 
-		if outputType == OutputTypeFiware {
-			fiware.CreateOrUpdateAirQualityObserved(ctx, contextBroker, sensors, d.DeviceName, d.UniqueId)
+		deviceID, err := strconv.Atoi(id)
+		if err != nil {
+			return time.Time{}, time.Time{}, nil, fmt.Errorf("invalid device ID %q: %w", id, err)
 		}
 
-		if outputType == OutputTypeLwm2m {
-			lwm2m.CreateAndSendAsLWM2M(ctx, sensors, d.UniqueId, lwm2mUrl, lwm2m.Send)
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+
+	return start, end, deviceIDs, nil
+}
+
+// splitList returns the non empty items of a comma separated list
+func splitList(list string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
 
+	return items
+}
+
+func intFromEnv(ctx context.Context, envVar string, defaultValue int) int {
+	value := env.GetVariableOrDefault(ctx, envVar, strconv.Itoa(defaultValue))
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		logging.GetFromContext(ctx).Error("invalid integer value in environment variable", "name", envVar, "value", value)
+		os.Exit(exitConfigError)
+	}
+
+	return i
+}
+
+func floatFromEnv(ctx context.Context, envVar string, defaultValue float64) float64 {
+	value := env.GetVariableOrDefault(ctx, envVar, strconv.FormatFloat(defaultValue, 'f', -1, 64))
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		logging.GetFromContext(ctx).Error("invalid decimal value in environment variable", "name", envVar, "value", value)
+		os.Exit(exitConfigError)
+	}
+
+	return f
+}
+
+func isFlagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
 }
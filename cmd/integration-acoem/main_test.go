@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"github.com/diwise/integration-acoem/internal/pkg/application/scheduler"
+	"github.com/matryer/is"
+)
+
+func TestExitCode(t *testing.T) {
+	unauthorizedDevice := fmt.Errorf("device 1: %w", application.ErrUnauthorized)
+
+	tests := map[string]struct {
+		err  error
+		code int
+	}{
+		"success":              {nil, exitOK},
+		"partial failure":      {errors.Join(scheduler.ErrSomeDevicesFailed, unauthorizedDevice), exitSomeDevicesFailed},
+		"all devices failed":   {errors.Join(scheduler.ErrAllDevicesFailed, unauthorizedDevice), exitAllDevicesFailed},
+		"unauthorized":         {fmt.Errorf("failed to retrieve devices: %w", application.ErrUnauthorized), exitUnauthorized},
+		"upstream unavailable": {fmt.Errorf("failed to retrieve devices: %w", application.ErrUpstreamUnavailable), exitFailure},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(tc.code, exitCode(tc.err))
+		})
+	}
+}
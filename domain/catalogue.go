@@ -0,0 +1,321 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Catalogue describes the sensors reported by Acoem that are known to the integration,
+// and how their readings are represented by each output
+type Catalogue struct {
+	Measurements []Measurement `yaml:"measurements"`
+	Units        []Unit        `yaml:"units"`
+}
+
+// Measurement maps an Acoem sensor to a canonical quantity and to the way it is
+// published to each output
+type Measurement struct {
+	SensorName  string `yaml:"sensorName"`  // the sensor name reported by Acoem, such as "Nitrogen Dioxide"
+	SensorLabel string `yaml:"sensorLabel"` // the sensor label reported by Acoem, such as "NO2"
+	Quantity    string `yaml:"quantity"`    // the canonical name of the measured quantity
+	Unit        string `yaml:"unit"`        // the UCUM code of the unit that Acoem usually reports the quantity in, and that fiware publishes it in
+
+	// the molecular weight in g/mol of a gas, used to convert between mixing ratios and mass concentrations
+	MolecularWeight float64 `yaml:"molecularWeight"`
+
+	// the plausible values in the unit of the measurement, outside of which a reading is an outlier
+	Range *Range `yaml:"range"`
+	// the largest plausible change between consecutive readings, or zero for no limit
+	MaxChange float64 `yaml:"maxChange"`
+
+	Property string         `yaml:"property"` // the NGSI-LD property used by fiware, or empty if not published
+	Lwm2m    *Lwm2mResource `yaml:"lwm2m"`    // the LwM2M resource, or nil if not published
+}
+
+// Range is an inclusive range of values
+type Range struct {
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+}
+
+// Contains reports whether v is within the range
+func (r Range) Contains(v float64) bool {
+	return v >= r.Min && v <= r.Max
+}
+
+// Lwm2mResource identifies the LwM2M object resource that a measurement is sent as
+type Lwm2mResource struct {
+	ObjectURN  string `yaml:"objectURN"` // such as "urn:oma:lwm2m:ext:3428"
+	ResourceID string `yaml:"resourceID"`
+	Unit       string `yaml:"unit"` // the SenML unit of the resource
+}
+
+// Unit maps a unit name reported by Acoem to its UCUM code and UN/CEFACT common code
+type Unit struct {
+	Name     string `yaml:"name"`     // such as "Parts Per Billion"
+	Code     string `yaml:"code"`     // UCUM, such as "[ppb]"
+	UnitCode string `yaml:"unitCode"` // UN/CEFACT, such as "61"
+}
+
+// Lookup returns the measurement of a channel, matching its sensor name or, failing
+// that, its sensor label, ignoring case
+func (c *Catalogue) Lookup(ch Channel) (Measurement, bool) {
+	for _, m := range c.Measurements {
+		if strings.EqualFold(m.SensorName, ch.SensorName) {
+			return m, true
+		}
+	}
+
+	if ch.SensorLabel != "" {
+		for _, m := range c.Measurements {
+			if m.SensorLabel != "" && strings.EqualFold(m.SensorLabel, ch.SensorLabel) {
+				return m, true
+			}
+		}
+	}
+
+	return Measurement{}, false
+}
+
+// UnitByName returns the unit with a name reported by Acoem, ignoring case
+func (c *Catalogue) UnitByName(name string) (Unit, bool) {
+	for _, u := range c.Units {
+		if strings.EqualFold(u.Name, name) {
+			return u, true
+		}
+	}
+	return Unit{}, false
+}
+
+// UnitByCode returns the unit with a UCUM code
+func (c *Catalogue) UnitByCode(code string) (Unit, bool) {
+	for _, u := range c.Units {
+		if u.Code == code {
+			return u, true
+		}
+	}
+	return Unit{}, false
+}
+
+// UnitOf returns the unit of a channel measurement, which is the unit named by the
+// channel if it is known and the unit of the measurement otherwise
+func (c *Catalogue) UnitOf(ch Channel, m Measurement) (Unit, bool) {
+	if u, ok := c.UnitByName(ch.UnitName); ok {
+		return u, true
+	}
+	return c.UnitByCode(m.Unit)
+}
+
+// The Air Quality object 3428 defines particulate matter in ug/m3 and every gas in ppm,
+// regardless of the unit reported by Acoem
+const (
+	lwm2mAirQuality  string = "urn:oma:lwm2m:ext:3428"
+	lwm2mHumidity    string = "urn:oma:lwm2m:ext:3304"
+	lwm2mTemperature string = "urn:oma:lwm2m:ext:3303"
+	lwm2mBarometer   string = "urn:oma:lwm2m:ext:3315"
+	lwm2mVoltage     string = "urn:oma:lwm2m:ext:3316"
+)
+
+// DefaultCatalogue returns the built-in catalogue of Acoem sensors and units
+func DefaultCatalogue() *Catalogue {
+	return &Catalogue{
+		Measurements: []Measurement{
+			{SensorName: "Temperature", SensorLabel: "TEMP", Quantity: "temperature", Unit: "Cel",
+				Property: "temperature", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mTemperature, ResourceID: "5700", Unit: "Cel"}, Range: &Range{Min: -60, Max: 60}},
+			{SensorName: "Humidity", SensorLabel: "HUM", Quantity: "relativeHumidity", Unit: "%",
+				Property: "relativeHumidity", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mHumidity, ResourceID: "5700", Unit: "%RH"}, Range: &Range{Min: 0, Max: 100}},
+			{SensorName: "Air Pressure", Quantity: "atmosphericPressure", Unit: "hPa",
+				Property: "atmosphericPressure", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mBarometer, ResourceID: "5700", Unit: "hPa"}, Range: &Range{Min: 800, Max: 1100}},
+			{SensorName: "Particulate Matter (PM 1)", SensorLabel: "PM1", Quantity: "pm1", Unit: "ug/m3",
+				Property: "PM1", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "5", Unit: "ug/m3"}, Range: &Range{Min: -10, Max: 1000}},
+			{SensorName: "Particulate Matter (PM 2.5)", SensorLabel: "PM2.5", Quantity: "pm2.5", Unit: "ug/m3",
+				Property: "PM25", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "3", Unit: "ug/m3"}, Range: &Range{Min: -10, Max: 1000}},
+			{SensorName: "PM 4", Quantity: "pm4", Unit: "ug/m3",
+				Property: "PM4", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "25", Unit: "ug/m3"}, Range: &Range{Min: -10, Max: 1000}},
+			{SensorName: "Particulate Matter (PM 10)", SensorLabel: "PM10", Quantity: "pm10", Unit: "ug/m3",
+				Property: "PM10", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "1", Unit: "ug/m3"}, Range: &Range{Min: -10, Max: 2000}},
+			{SensorName: "Total Suspended Particulate", Quantity: "totalSuspendedParticulate", Unit: "ug/m3",
+				Property: "totalSuspendedParticulate", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "27", Unit: "ug/m3"}, Range: &Range{Min: -10, Max: 5000}},
+			{SensorName: "Voltage", Quantity: "voltage", Unit: "V",
+				Property: "voltage", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mVoltage, ResourceID: "5700", Unit: "V"}, Range: &Range{Min: 0, Max: 60}},
+			{SensorName: "Nitric Oxide", SensorLabel: "NO", Quantity: "nitricOxide", Unit: "[ppb]", MolecularWeight: 30.006,
+				Property: "NO", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "19", Unit: "ppm"}, Range: &Range{Min: -50, Max: 5000}},
+			{SensorName: "Nitrogen Dioxide", SensorLabel: "NO2", Quantity: "nitrogenDioxide", Unit: "[ppb]", MolecularWeight: 46.0055,
+				Property: "NO2", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "15", Unit: "ppm"}, Range: &Range{Min: -50, Max: 2000}},
+			{SensorName: "Nitrogen Oxides", SensorLabel: "NOx", Quantity: "nitrogenOxides", Unit: "[ppb]", MolecularWeight: 46.0055,
+				Property: "NOx", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "13", Unit: "ppm"}, Range: &Range{Min: -50, Max: 7000}},
+			{SensorName: "Ozone", SensorLabel: "O3", Quantity: "ozone", Unit: "[ppb]", MolecularWeight: 47.997,
+				Property: "O3", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "9", Unit: "ppm"}, Range: &Range{Min: -50, Max: 500}},
+			{SensorName: "Sulphur Dioxide", SensorLabel: "SO2", Quantity: "sulphurDioxide", Unit: "[ppb]", MolecularWeight: 64.066,
+				Property: "SO2", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "17", Unit: "ppm"}, Range: &Range{Min: -50, Max: 2000}},
+			{SensorName: "Carbon Monoxide", SensorLabel: "CO", Quantity: "carbonMonoxide", Unit: "[ppb]", MolecularWeight: 28.010,
+				Property: "CO", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "7", Unit: "ppm"}, Range: &Range{Min: -500, Max: 100000}},
+			{SensorName: "Carbon Dioxide", SensorLabel: "CO2", Quantity: "carbonDioxide", Unit: "[ppm]", MolecularWeight: 44.009,
+				Property: "CO2", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "23", Unit: "ppm"}, Range: &Range{Min: 0, Max: 10000}},
+			{SensorName: "Hydrogen Sulphide", SensorLabel: "H2S", Quantity: "hydrogenSulphide", Unit: "[ppb]", MolecularWeight: 34.081,
+				Property: "H2S", Lwm2m: &Lwm2mResource{ObjectURN: lwm2mAirQuality, ResourceID: "21", Unit: "ppm"}, Range: &Range{Min: -50, Max: 1000}},
+		},
+		Units: []Unit{
+			{Name: "Micrograms Per Cubic Meter", Code: "ug/m3", UnitCode: "GQ"},
+			{Name: "Volts", Code: "V", UnitCode: "VLT"},
+			{Name: "Celsius", Code: "Cel", UnitCode: "CEL"},
+			{Name: "Percent", Code: "%", UnitCode: "P1"},
+			{Name: "Hectopascals", Code: "hPa", UnitCode: "A97"},
+			{Name: "Parts Per Billion", Code: "[ppb]", UnitCode: "61"},
+			{Name: "Parts Per Million", Code: "[ppm]", UnitCode: "59"},
+			{Name: "Pressure (mbar)", Code: "mbar", UnitCode: "MBR"},
+		},
+	}
+}
+
+// LoadCatalogue reads a catalogue in YAML or JSON format and merges it into the default
+// catalogue. Measurements and units in the file replace the defaults with the same sensor
+// name or unit name, and are added to the catalogue otherwise.
+func LoadCatalogue(r io.Reader) (*Catalogue, error) {
+	file := Catalogue{}
+
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(&file)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse catalogue: %w", err)
+	}
+
+	c := DefaultCatalogue()
+
+	// only the defaults are replaced, so that duplicates within the file are found by Validate
+	defaultMeasurements, defaultUnits := len(c.Measurements), len(c.Units)
+
+	for _, m := range file.Measurements {
+		idx := slices.IndexFunc(c.Measurements[:defaultMeasurements], func(d Measurement) bool {
+			return strings.EqualFold(d.SensorName, m.SensorName)
+		})
+		if idx >= 0 {
+			c.Measurements[idx] = m
+		} else {
+			c.Measurements = append(c.Measurements, m)
+		}
+	}
+
+	for _, u := range file.Units {
+		idx := slices.IndexFunc(c.Units[:defaultUnits], func(d Unit) bool {
+			return strings.EqualFold(d.Name, u.Name)
+		})
+		if idx >= 0 {
+			c.Units[idx] = u
+		} else {
+			c.Units = append(c.Units, u)
+		}
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Validate returns an error describing every problem found in the catalogue
+func (c *Catalogue) Validate() error {
+	errs := []error{}
+
+	unitNames := map[string]bool{}
+	for idx, u := range c.Units {
+		if u.Name == "" || u.Code == "" {
+			errs = append(errs, fmt.Errorf("unit %d: both name and code are required", idx))
+		}
+		if unitNames[strings.ToLower(u.Name)] {
+			errs = append(errs, fmt.Errorf("unit %q: defined more than once", u.Name))
+		}
+		unitNames[strings.ToLower(u.Name)] = true
+	}
+
+	sensorNames := map[string]bool{}
+	sensorLabels := map[string]bool{}
+	properties := map[string]string{}
+	resources := map[string]string{}
+
+	for idx, m := range c.Measurements {
+		if m.SensorName == "" {
+			errs = append(errs, fmt.Errorf("measurement %d: sensor name is required", idx))
+			continue
+		}
+
+		name := m.SensorName
+
+		if sensorNames[strings.ToLower(name)] {
+			errs = append(errs, fmt.Errorf("measurement %q: defined more than once", name))
+		}
+		sensorNames[strings.ToLower(name)] = true
+
+		if m.SensorLabel != "" {
+			if sensorLabels[strings.ToLower(m.SensorLabel)] {
+				errs = append(errs, fmt.Errorf("measurement %q: sensor label %q is used by another measurement", name, m.SensorLabel))
+			}
+			sensorLabels[strings.ToLower(m.SensorLabel)] = true
+		}
+
+		if m.Quantity == "" {
+			errs = append(errs, fmt.Errorf("measurement %q: quantity is required", name))
+		}
+
+		if _, ok := c.UnitByCode(m.Unit); !ok {
+			errs = append(errs, fmt.Errorf("measurement %q: unknown unit %q", name, m.Unit))
+		}
+
+		if m.Range != nil && m.Range.Min >= m.Range.Max {
+			errs = append(errs, fmt.Errorf("measurement %q: range minimum must be less than maximum", name))
+		}
+
+		if m.MaxChange < 0 {
+			errs = append(errs, fmt.Errorf("measurement %q: max change must not be negative", name))
+		}
+
+		if m.MolecularWeight < 0 {
+			errs = append(errs, fmt.Errorf("measurement %q: molecular weight must not be negative", name))
+		}
+
+		if m.Property != "" {
+			if other, ok := properties[m.Property]; ok {
+				errs = append(errs, fmt.Errorf("measurement %q: property %q is also used by %q", name, m.Property, other))
+			}
+			properties[m.Property] = name
+		}
+
+		if m.Lwm2m != nil {
+			r := m.Lwm2m
+
+			if !strings.HasPrefix(r.ObjectURN, "urn:oma:lwm2m:") {
+				errs = append(errs, fmt.Errorf("measurement %q: invalid lwm2m object urn %q", name, r.ObjectURN))
+			}
+
+			if _, err := strconv.Atoi(r.ResourceID); err != nil {
+				errs = append(errs, fmt.Errorf("measurement %q: lwm2m resource id %q is not a number", name, r.ResourceID))
+			}
+
+			if r.Unit == "" {
+				errs = append(errs, fmt.Errorf("measurement %q: lwm2m unit is required", name))
+			}
+
+			resource := r.ObjectURN + "/" + r.ResourceID
+			if other, ok := resources[resource]; ok {
+				errs = append(errs, fmt.Errorf("measurement %q: lwm2m resource %s is also used by %q", name, resource, other))
+			}
+			resources[resource] = name
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid catalogue: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestLookupMatchesSensorNameIgnoringCase(t *testing.T) {
+	is := is.New(t)
+
+	m, ok := DefaultCatalogue().Lookup(Channel{SensorName: "nitrogen dioxide"})
+	is.True(ok)
+	is.Equal("NO2", m.Property)
+	is.Equal("15", m.Lwm2m.ResourceID)
+}
+
+func TestLookupFallsBackToSensorLabel(t *testing.T) {
+	is := is.New(t)
+
+	m, ok := DefaultCatalogue().Lookup(Channel{SensorName: "PM10 (BAM)", SensorLabel: "PM10"})
+	is.True(ok)
+	is.Equal("pm10", m.Quantity)
+
+	_, ok = DefaultCatalogue().Lookup(Channel{SensorName: "Unknown", SensorLabel: "UNK"})
+	is.True(!ok)
+}
+
+func TestUnitOfPrefersTheUnitOfTheChannel(t *testing.T) {
+	is := is.New(t)
+
+	c := DefaultCatalogue()
+	m, _ := c.Lookup(Channel{SensorName: "Air Pressure"})
+
+	u, ok := c.UnitOf(Channel{UnitName: "Pressure (mbar)"}, m)
+	is.True(ok)
+	is.Equal("MBR", u.UnitCode)
+
+	u, ok = c.UnitOf(Channel{UnitName: "Unknown"}, m)
+	is.True(ok)
+	is.Equal("A97", u.UnitCode)
+}
+
+func TestDefaultCatalogueIsValid(t *testing.T) {
+	is := is.New(t)
+	is.NoErr(DefaultCatalogue().Validate())
+}
+
+func TestLoadCatalogueMergesFileWithDefaults(t *testing.T) {
+	is := is.New(t)
+
+	file := `
+measurements:
+  - sensorName: Ammonia
+    sensorLabel: NH3
+    quantity: ammonia
+    unit: mg/m3
+    property: NH3
+  - sensorName: nitrogen dioxide
+    quantity: nitrogenDioxide
+    unit: "[ppb]"
+    property: nitrogenDioxide
+units:
+  - name: Milligrams Per Cubic Meter
+    code: mg/m3
+    unitCode: GP
+`
+
+	c, err := LoadCatalogue(strings.NewReader(file))
+	is.NoErr(err)
+	is.Equal(len(DefaultCatalogue().Measurements)+1, len(c.Measurements))
+
+	m, ok := c.Lookup(Channel{SensorName: "Ammonia"})
+	is.True(ok)
+	is.Equal("NH3", m.Property)
+
+	u, _ := c.UnitOf(Channel{}, m)
+	is.Equal("GP", u.UnitCode)
+
+	m, ok = c.Lookup(Channel{SensorName: "Nitrogen Dioxide"})
+	is.True(ok)
+	is.Equal("nitrogenDioxide", m.Property)
+	is.True(m.Lwm2m == nil) // the whole measurement is replaced
+}
+
+func TestLoadCatalogueAcceptsJSON(t *testing.T) {
+	is := is.New(t)
+
+	file := `{"measurements": [{"sensorName": "Ammonia", "quantity": "ammonia", "unit": "[ppb]", "property": "NH3"}]}`
+
+	c, err := LoadCatalogue(strings.NewReader(file))
+	is.NoErr(err)
+
+	_, ok := c.Lookup(Channel{SensorName: "Ammonia"})
+	is.True(ok)
+}
+
+func TestLoadCatalogueRejectsInvalidMappings(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":       "measurements:\n  - sensorName: Ammonia\n    quantiy: ozone\n",
+		"missing quantity":    "measurements:\n  - sensorName: Ammonia\n    unit: \"[ppb]\"\n",
+		"unknown unit":        "measurements:\n  - sensorName: Ammonia\n    quantity: ammonia\n    unit: \"mg/m3\"\n",
+		"duplicate property":  "measurements:\n  - sensorName: Ammonia\n    quantity: ammonia\n    unit: \"[ppb]\"\n    property: NO2\n",
+		"duplicate sensor":    "measurements:\n  - sensorName: Ammonia\n    quantity: ammonia\n    unit: \"[ppb]\"\n  - sensorName: AMMONIA\n    quantity: ammonia\n    unit: \"[ppb]\"\n",
+		"invalid resource":    "measurements:\n  - sensorName: Ammonia\n    quantity: ammonia\n    unit: \"[ppb]\"\n    lwm2m:\n      objectURN: urn:oma:lwm2m:ext:3428\n      resourceID: nine\n      unit: ppb\n",
+		"duplicate resource":  "measurements:\n  - sensorName: Ammonia\n    quantity: ammonia\n    unit: \"[ppb]\"\n    lwm2m:\n      objectURN: urn:oma:lwm2m:ext:3428\n      resourceID: \"15\"\n      unit: ppb\n",
+		"incomplete unit":     "units:\n  - name: Milligrams Per Cubic Meter\n",
+		"empty range":         "measurements:\n  - sensorName: Ammonia\n    quantity: ammonia\n    unit: \"[ppb]\"\n    range:\n      min: 10\n      max: 10\n",
+		"negative max change": "measurements:\n  - sensorName: Ammonia\n    quantity: ammonia\n    unit: \"[ppb]\"\n    maxChange: -1\n",
+	}
+
+	for name, file := range tests {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := LoadCatalogue(strings.NewReader(file))
+			is.True(err != nil)
+		})
+	}
+}
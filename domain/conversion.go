@@ -0,0 +1,141 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Conditions are the ambient temperature and pressure used when converting between
+// mixing ratios, such as ppb, and mass concentrations, such as µg/m³
+type Conditions struct {
+	Temperature float64 // degrees Celsius
+	Pressure    float64 // hectopascals
+}
+
+// ReferenceConditions are used when a device does not report temperature or pressure. They
+// are the reference conditions for gases in the EU air quality directive 2008/50/EC.
+var ReferenceConditions = Conditions{Temperature: 20, Pressure: 1013}
+
+const gasConstant float64 = 8.314462618 // J/(mol·K)
+
+const (
+	dimensionMixingRatio       string = "mixing ratio"
+	dimensionMassConcentration string = "mass concentration"
+	dimensionPressure          string = "pressure"
+	dimensionTemperature       string = "temperature"
+	dimensionVoltage           string = "voltage"
+	dimensionRatio             string = "ratio"
+)
+
+type conversionUnit struct {
+	dimension string
+	factor    float64 // the factor that converts a value in the unit to the base unit of the dimension
+}
+
+// conversionUnits are keyed by both UCUM codes, as used by the catalogue, and SenML units,
+// as used by lwm2m resources. The base units are mole fraction, kg/m³, Pa, °C, V and ratio.
+var conversionUnits = map[string]conversionUnit{
+	"[ppm]": {dimensionMixingRatio, 1e-6},
+	"ppm":   {dimensionMixingRatio, 1e-6},
+	"[ppb]": {dimensionMixingRatio, 1e-9},
+	"ppb":   {dimensionMixingRatio, 1e-9},
+	"mg/m3": {dimensionMassConcentration, 1e-6},
+	"ug/m3": {dimensionMassConcentration, 1e-9},
+	"Pa":    {dimensionPressure, 1},
+	"hPa":   {dimensionPressure, 100},
+	"mbar":  {dimensionPressure, 100},
+	"kPa":   {dimensionPressure, 1000},
+	"Cel":   {dimensionTemperature, 1},
+	"V":     {dimensionVoltage, 1},
+	"mV":    {dimensionVoltage, 1e-3},
+	"%":     {dimensionRatio, 1e-2},
+	"%RH":   {dimensionRatio, 1e-2},
+	"/":     {dimensionRatio, 1},
+}
+
+// Convert returns a reading of a channel in the unit given by code, which is either a UCUM code
+// or a SenML unit. The reading is assumed to be in the unit reported by the channel or, if that
+// is unknown, in the unit of the measurement. Conversions between mixing ratios and mass
+// concentrations use the molecular weight of the measurement and the given conditions.
+func (c *Catalogue) Convert(ch Channel, m Measurement, reading float64, code string, conditions Conditions) (float64, error) {
+	from := m.Unit
+	if u, ok := c.UnitOf(ch, m); ok {
+		from = u.Code
+	}
+
+	if from == code {
+		return reading, nil
+	}
+
+	src, ok := conversionUnits[from]
+	if !ok {
+		return 0, fmt.Errorf("cannot convert %s from unsupported unit %q", m.SensorName, from)
+	}
+
+	dst, ok := conversionUnits[code]
+	if !ok {
+		return 0, fmt.Errorf("cannot convert %s to unsupported unit %q", m.SensorName, code)
+	}
+
+	if src == dst {
+		// the same unit in UCUM and SenML
+		return reading, nil
+	}
+
+	base := reading * src.factor
+
+	if src.dimension != dst.dimension {
+		var err error
+		base, err = convertGas(base, src.dimension, dst.dimension, m, conditions)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return base / dst.factor, nil
+}
+
+// convertGas converts between a mole fraction and a mass concentration in kg/m³ using the ideal gas law
+func convertGas(value float64, from, to string, m Measurement, conditions Conditions) (float64, error) {
+	if m.MolecularWeight <= 0 {
+		return 0, fmt.Errorf("cannot convert %s from %s to %s without a molecular weight", m.SensorName, from, to)
+	}
+
+	// moles of air per m³, multiplied by the molar mass in kg/mol
+	kgPerM3 := (conditions.Pressure * 100) / (gasConstant * (conditions.Temperature + 273.15)) * m.MolecularWeight / 1000
+
+	switch {
+	case from == dimensionMixingRatio && to == dimensionMassConcentration:
+		return value * kgPerM3, nil
+	case from == dimensionMassConcentration && to == dimensionMixingRatio:
+		return value / kgPerM3, nil
+	}
+
+	return 0, fmt.Errorf("cannot convert %s from %s to %s", m.SensorName, from, to)
+}
+
+// ConditionsOf returns the ambient conditions reported by the temperature and pressure channels
+// among channels, using the reference conditions for values that are not reported
+func (c *Catalogue) ConditionsOf(channels []Channel) Conditions {
+	conditions := ReferenceConditions
+
+	for _, ch := range channels {
+		m, ok := c.Lookup(ch)
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(m.Quantity) {
+		case "temperature":
+			if t, err := c.Convert(ch, m, ch.Scaled.Reading, "Cel", conditions); err == nil {
+				conditions.Temperature = t
+			}
+		case "atmosphericpressure":
+			if p, err := c.Convert(ch, m, ch.Scaled.Reading, "hPa", conditions); err == nil && p > 0 {
+				conditions.Pressure = p
+			}
+		}
+	}
+
+	return conditions
+}
@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"math"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestConvertBetweenMixingRatios(t *testing.T) {
+	is := is.New(t)
+
+	c := DefaultCatalogue()
+	ch := Channel{SensorName: "Nitrogen Dioxide", UnitName: "Parts Per Billion"}
+	m, _ := c.Lookup(ch)
+
+	v, err := c.Convert(ch, m, 21, "ppm", ReferenceConditions)
+	is.NoErr(err)
+	is.True(math.Abs(v-0.021) < 1e-12)
+
+	v, err = c.Convert(ch, m, 21, "ppb", ReferenceConditions)
+	is.NoErr(err)
+	is.Equal(21.0, v)
+}
+
+func TestConvertMixingRatioToMassConcentration(t *testing.T) {
+	is := is.New(t)
+
+	c := DefaultCatalogue()
+	ch := Channel{SensorName: "Nitrogen Dioxide", UnitName: "Parts Per Billion"}
+	m, _ := c.Lookup(ch)
+
+	v, err := c.Convert(ch, m, 10, "ug/m3", ReferenceConditions)
+	is.NoErr(err)
+	is.Equal(19.12, math.Round(v*100)/100) // 1 ppb NO2 is 1.912 µg/m³ at 20 °C and 1013 hPa
+
+	v, err = c.Convert(ch, m, 10, "ug/m3", Conditions{Temperature: 25, Pressure: 1013.25})
+	is.NoErr(err)
+	is.Equal(18.8, math.Round(v*10)/10) // the conventional 1.88 µg/m³ per ppb at 25 °C
+}
+
+func TestConvertMassConcentrationToMixingRatio(t *testing.T) {
+	is := is.New(t)
+
+	c := DefaultCatalogue()
+	ch := Channel{SensorName: "Ozone", UnitName: "Micrograms Per Cubic Meter"}
+	m, _ := c.Lookup(ch)
+
+	v, err := c.Convert(ch, m, 100, "[ppb]", ReferenceConditions)
+	is.NoErr(err)
+	is.Equal(50.1, math.Round(v*10)/10)
+}
+
+func TestConvertFailsForIncompatibleUnits(t *testing.T) {
+	is := is.New(t)
+
+	c := DefaultCatalogue()
+
+	ch := Channel{SensorName: "Particulate Matter (PM 10)"}
+	m, _ := c.Lookup(ch)
+	_, err := c.Convert(ch, m, 10, "ppm", ReferenceConditions)
+	is.True(err != nil) // no molecular weight
+
+	ch = Channel{SensorName: "Voltage"}
+	m, _ = c.Lookup(ch)
+	_, err = c.Convert(ch, m, 10, "hPa", ReferenceConditions)
+	is.True(err != nil)
+
+	_, err = c.Convert(ch, m, 10, "furlong", ReferenceConditions)
+	is.True(err != nil)
+}
+
+func TestConditionsOfUsesColocatedChannels(t *testing.T) {
+	is := is.New(t)
+
+	c := DefaultCatalogue()
+
+	temperature := Channel{SensorName: "Temperature", UnitName: "Celsius"}
+	temperature.Scaled.Reading = 4.5
+
+	pressure := Channel{SensorName: "Air Pressure", UnitName: "Pressure (mbar)"}
+	pressure.Scaled.Reading = 987
+
+	is.Equal(Conditions{Temperature: 4.5, Pressure: 987}, c.ConditionsOf([]Channel{temperature, pressure}))
+	is.Equal(ReferenceConditions, c.ConditionsOf([]Channel{{SensorName: "Voltage"}}))
+}
@@ -1,41 +1,107 @@
 package domain
 
+import (
+	"fmt"
+	"strings"
+)
+
+// Device is a monitor as listed by the devices endpoint of Acoem
 type Device struct {
-	UniqueId   int    `json:"uniqueID"`
-	DeviceName string `json:"deviceName"`
+	UniqueId       int     `json:"uniqueID"`
+	DeviceName     string  `json:"deviceName"`
+	DeviceType     string  `json:"deviceType"` // such as "Gen2 Logger"
+	SerialNumber   int     `json:"serialNumber"`
+	Firmware       string  `json:"firmware"`
+	Customer       string  `json:"customer"`
+	Imsi           string  `json:"imsi"`
+	LastConnection string  `json:"lastConnection"` // RFC3339, empty if the device has never connected
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	Altitude       float64 `json:"altitude"`
 }
 
+// DeviceData is a record of the channels of a device at a point in time
 type DeviceData struct {
-	Timestamp struct {
-		Convention string `json:"convention"`
-		Timestamp  string `json:"timestamp"`
-	} `json:"timestamp"`
-	Location struct {
-		Altitude  float64 `json:"altitude"`
-		Longitude float64 `json:"longitude"`
-		Latitude  float64 `json:"latitude"`
-	} `json:"location"`
-	Channels []Channel `json:"channels"`
+	Timestamp Timestamp `json:"timestamp"`
+	Location  Location  `json:"location"`
+	Channels  []Channel `json:"channels"`
+}
+
+type Timestamp struct {
+	Convention string `json:"convention"` // whether the timestamp is at the beginning or end of the averaging period, such as "TimeBeginning"
+	Timestamp  string `json:"timestamp"`  // RFC3339
+}
+
+type Location struct {
+	Altitude  float64 `json:"altitude"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
 }
 
 type Channel struct {
-	SensorName  string `json:"sensorName"`
-	SensorLabel string `json:"sensorLabel"`
-	Channel     int    `json:"channel"`
-	PreScaled   struct {
-		Reading float64 `json:"reading"`
-	} `json:"preScaled"`
-	Scaled struct {
-		Reading float64 `json:"reading"`
-	} `json:"scaled"`
-	UnitName string   `json:"unitName"`
-	Slope    int      `json:"slope"`
-	Offset   int      `json:"offset"`
-	Flags    []string `json:"flags"`
+	SensorName         string   `json:"sensorName"`
+	SensorLabel        string   `json:"sensorLabel"`
+	Channel            int      `json:"channel"`
+	UniqueId           int      `json:"uniqueId"` // the device the channel belongs to
+	PreScaled          Reading  `json:"preScaled"`
+	Scaled             Reading  `json:"scaled"`
+	RedactedPercentage float64  `json:"redactedPercentage"`
+	UnitName           string   `json:"unitName"`
+	Slope              float64  `json:"slope"`
+	Offset             float64  `json:"offset"`
+	DataRate           int      `json:"dataRate"` // the number of seconds between the raw readings that are averaged
+	Flags              []string `json:"flags"`
+
+	// Annotations describe data quality issues found by the integration, such as flags that
+	// the quality policy is configured to report, and are not part of the Acoem payload
+	Annotations []string `json:"annotations,omitempty"`
+	// Calibration is the version of the local calibration applied to the published reading, if any
+	Calibration string `json:"calibration,omitempty"`
+}
+
+// Reading is a value of a channel, before or after scaling, along with its data quality
+type Reading struct {
+	Reading         float64  `json:"reading"`
+	ValidPercentage *float64 `json:"validPercentage,omitempty"` // the share of the averaging period with valid data, if reported
+	Flags           []string `json:"flags"`
 }
 
+// Sensor is a sensor in the setup of a device
 type Sensor struct {
-	Active      bool   `json:"active"`
-	SensorLabel string `json:"sensorLabel"`
-	Type        string `json:"type"`
+	Active      bool    `json:"active"`
+	SensorName  string  `json:"sensorName"`
+	SensorLabel string  `json:"sensorLabel"`
+	Channel     int     `json:"channel"`
+	Type        string  `json:"type"` // data or diagnostic
+	UnitName    string  `json:"unitName"`
+	Slope       float64 `json:"slope"`
+	Offset      float64 `json:"offset"`
+}
+
+// ReadingSelection decides which of the readings of a channel is published
+type ReadingSelection string
+
+const (
+	ReadingScaled    ReadingSelection = "scaled"    // the reading with the slope and offset configured in Acoem applied
+	ReadingPreScaled ReadingSelection = "prescaled" // the reading as measured by the sensor
+	ReadingBoth      ReadingSelection = "both"      // the scaled reading, with the prescaled reading alongside it where supported
+)
+
+// ParseReadingSelection returns the reading selection with the given name, ignoring case
+func ParseReadingSelection(name string) (ReadingSelection, error) {
+	selection := ReadingSelection(strings.ToLower(name))
+
+	if selection != ReadingScaled && selection != ReadingPreScaled && selection != ReadingBoth {
+		return "", fmt.Errorf("invalid reading %q, expected one of %s, %s or %s", name, ReadingScaled, ReadingPreScaled, ReadingBoth)
+	}
+
+	return selection, nil
+}
+
+// Value returns the reading of a channel to publish
+func (s ReadingSelection) Value(ch Channel) float64 {
+	if s == ReadingPreScaled {
+		return ch.PreScaled.Reading
+	}
+	return ch.Scaled.Reading
 }
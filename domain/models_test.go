@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestChannelDecodesTheAcoemPayload(t *testing.T) {
+	is := is.New(t)
+
+	payload := `{
+		"Channel": 3, "DataRate": 60, "Offset": -0.35, "Slope": 1.07, "RedactedPercentage": 12.5,
+		"PreScaled": {"Flags": ["Calibration"], "Reading": 10.2, "ValidPercentage": 87.5},
+		"Scaled": {"Flags": null, "Reading": 10.56},
+		"SensorLabel": "PM10", "SensorName": "Particulate Matter (PM 10)", "UniqueId": 888100,
+		"UnitName": "Micrograms Per Cubic Meter"
+	}`
+
+	var ch Channel
+	is.NoErr(json.Unmarshal([]byte(payload), &ch))
+
+	is.Equal(1.07, ch.Slope)
+	is.Equal(-0.35, ch.Offset)
+	is.Equal(60, ch.DataRate)
+	is.Equal(888100, ch.UniqueId)
+	is.Equal(12.5, ch.RedactedPercentage)
+	is.Equal([]string{"Calibration"}, ch.PreScaled.Flags)
+	is.Equal(87.5, *ch.PreScaled.ValidPercentage)
+	is.True(ch.Scaled.ValidPercentage == nil)
+}
+
+func TestReadingSelection(t *testing.T) {
+	is := is.New(t)
+
+	ch := Channel{}
+	ch.PreScaled.Reading = 10.2
+	ch.Scaled.Reading = 10.56
+
+	for name, expected := range map[string]float64{"scaled": 10.56, "PreScaled": 10.2, "both": 10.56} {
+		selection, err := ParseReadingSelection(name)
+		is.NoErr(err)
+		is.Equal(expected, selection.Value(ch))
+	}
+
+	_, err := ParseReadingSelection("raw")
+	is.True(err != nil)
+}
@@ -7,28 +7,47 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/diwise/integration-acoem/domain"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type IntegrationAcoem interface {
 	GetDevices(ctx context.Context) ([]domain.Device, error)
 	GetDeviceData(ctx context.Context, uniqueId int, sensorLabels string) ([]domain.DeviceData, error)
+	GetDeviceDataRange(ctx context.Context, uniqueId int, sensorLabels string, from, to time.Time) ([]domain.DeviceData, error)
 	GetSensorLabels(ctx context.Context, deviceID int) (string, error)
 }
 
 type integrationAcoem struct {
 	baseUrl     string
 	accessToken string
+
+	numberOfRecords int
+	average         string
+	dataType        string
+
+	retry      retryPolicy
+	limiter    *tokenBucket
+	setupCache *setupCache
+	httpClient *http.Client
+
+	requests metric.Int64Counter
 }
 
 var tracer = otel.Tracer("integration-acoem/app")
 
-func New(baseUrl, accountID, accountKey string) IntegrationAcoem {
+// acoemTimeFormat is the layout used for start and end times in the devicedata range endpoint
+const acoemTimeFormat string = "2006-01-02T15:04:05"
+
+func New(baseUrl, accountID, accountKey string, opts ...Option) (IntegrationAcoem, error) {
 	accessToken := fmt.Sprintf(
 		"Basic %s",
 		base64.StdEncoding.EncodeToString(
@@ -36,10 +55,39 @@ func New(baseUrl, accountID, accountKey string) IntegrationAcoem {
 		),
 	)
 
-	return &integrationAcoem{
+	i := &integrationAcoem{
 		baseUrl:     baseUrl,
 		accessToken: accessToken,
+
+		numberOfRecords: 1,
+		average:         "AVG300",
+		dataType:        DataTypeData,
+
+		retry: retryPolicy{
+			maxRetries: 3,
+			baseDelay:  1 * time.Second,
+			maxDelay:   30 * time.Second,
+		},
+		setupCache: newSetupCache(1 * time.Hour),
+		requests:   newRequestCounter(),
 	}
+
+	for _, opt := range opts {
+		if err := opt(i); err != nil {
+			return nil, err
+		}
+	}
+
+	// every attempt is rate limited and traced separately, while the retries themselves
+	// are recorded as events on the span of the calling method
+	i.httpClient = &http.Client{
+		Transport: &retryTransport{
+			next:   newRateLimitedTransport(otelhttp.NewTransport(http.DefaultTransport), i.limiter),
+			policy: i.retry,
+		},
+	}
+
+	return i, nil
 }
 
 func (i *integrationAcoem) GetSensorLabels(ctx context.Context, deviceID int) (string, error) {
@@ -48,116 +96,102 @@ func (i *integrationAcoem) GetSensorLabels(ctx context.Context, deviceID int) (s
 	ctx, span := tracer.Start(ctx, "get-sensor-labels")
 	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
 
-	httpClient := http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
-	}
-
-	var req *http.Request
-	req, err = http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/devices/setup/%d", i.baseUrl, deviceID), nil)
-	if err != nil {
-		err = fmt.Errorf("failed to create request: %s", err.Error())
-		return "", err
-	}
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", i.accessToken)
-
-	var resp *http.Response
-	resp, err = httpClient.Do(req)
-	if err != nil {
-		err = fmt.Errorf("request failed: %s", err.Error())
-		return "", err
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("request failed, expected status code %d but got %d", http.StatusOK, resp.StatusCode)
-		return "", err
+	if labels, ok := i.setupCache.get(deviceID, time.Now()); ok {
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		return labels, nil
 	}
 
 	sensors := []domain.Sensor{}
 
-	var bodyBytes []byte
-	bodyBytes, err = io.ReadAll(resp.Body)
-	if err != nil {
-		err = fmt.Errorf("failed to read response body: %s", err.Error())
-		return "", err
-	}
-
-	err = json.Unmarshal(bodyBytes, &sensors)
+	err = i.get(ctx, EndpointDeviceSetup, fmt.Sprintf("%s/devices/setup/%d", i.baseUrl, deviceID), nil, &sensors)
 	if err != nil {
-		err = fmt.Errorf("failed to unmarshal response body: %s", err.Error())
 		return "", err
 	}
 
 	sensorLabels := []string{}
 
 	for _, s := range sensors {
-		if s.Active && s.Type == "data" {
+		if s.Active && i.includesSensorType(s.Type) {
 			sensorLabels = append(sensorLabels, s.SensorLabel)
 		}
 	}
 
 	labels := strings.Join(sensorLabels, "+")
 
+	i.setupCache.set(deviceID, labels, time.Now())
+
 	return labels, nil
 }
 
+// includesSensorType reports whether sensors of the given type from the device setup
+// should be requested, given the configured data type
+func (i *integrationAcoem) includesSensorType(sensorType string) bool {
+	if i.dataType == DataTypeDataDiagnostic {
+		return sensorType == DataTypeData || sensorType == DataTypeDiagnostic
+	}
+	return sensorType == i.dataType
+}
+
 func (i *integrationAcoem) GetDeviceData(ctx context.Context, uniqueId int, sensorLabels string) ([]domain.DeviceData, error) {
 	var err error
 
 	ctx, span := tracer.Start(ctx, "get-device-data")
 	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
 
-	httpClient := http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
-	}
 	if uniqueId == 0 || sensorLabels == "" {
-		err = fmt.Errorf("cannot retrieve sensor data as either uniqueId or sensor labels are empty")
+		err = fmt.Errorf("%w: cannot retrieve sensor data as either uniqueId or sensor labels are empty", ErrInvalidArgument)
 		return nil, err
 	}
-	deviceData := []domain.DeviceData{}
 
-	numberOfRecords := 1 //The number of records you want to retrieve
-	average := "AVG300"  //'AVG' or 'AVERAGE' followed by the average period in seconds. Valid seconds are: 0, 300, 600, 900, 1200, 1800, 3600, 7200, 10800, 14400, 21600, 28800, 43200, 86400
-	type_ := "data"      //This can be 'data', 'diagnostic' or 'datadiagnostic'
+	devicedataUrl := fmt.Sprintf("%s/devicedata/%d/latest/%d/%s/%s/%s", i.baseUrl, uniqueId, i.numberOfRecords, i.average, i.dataType, sensorLabels)
 
-	devicedataUrl := fmt.Sprintf("%s/devicedata/%d/latest/%d/%s/%s/%s", i.baseUrl, uniqueId, numberOfRecords, average, type_, sensorLabels)
+	var deviceData []domain.DeviceData
+	deviceData, err = i.getDeviceData(ctx, devicedataUrl)
+	if err == nil && !labelsMatch(sensorLabels, deviceData) {
+		i.setupCache.invalidate(uniqueId)
+	}
 
-	var req *http.Request
-	req, err = http.NewRequestWithContext(ctx, http.MethodGet, devicedataUrl, nil)
-	if err != nil {
-		err = fmt.Errorf("failed to create request: %s", err.Error())
+	return deviceData, err
+}
+
+func (i *integrationAcoem) GetDeviceDataRange(ctx context.Context, uniqueId int, sensorLabels string, from, to time.Time) ([]domain.DeviceData, error) {
+	var err error
+
+	ctx, span := tracer.Start(ctx, "get-device-data-range")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	if uniqueId == 0 || sensorLabels == "" {
+		err = fmt.Errorf("%w: cannot retrieve sensor data as either uniqueId or sensor labels are empty", ErrInvalidArgument)
 		return nil, err
 	}
 
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", i.accessToken)
-	//req.Header.Add("TimeConvention", "TimeBeginning")
-	req.Header.Add("TimeConvention", "TimeEnding") //The time convention for the request
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		err = fmt.Errorf("failed to retrieve sensor data: %s", err.Error())
+	if !from.Before(to) {
+		err = fmt.Errorf("%w: cannot retrieve sensor data as start time %s is not before end time %s", ErrInvalidArgument, from.Format(time.RFC3339), to.Format(time.RFC3339))
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	devicedataUrl := fmt.Sprintf("%s/devicedata/%d/%s/%s/%s/%s/%s", i.baseUrl, uniqueId,
+		from.UTC().Format(acoemTimeFormat), to.UTC().Format(acoemTimeFormat), i.average, i.dataType, sensorLabels)
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("request failed, expected status code %d, got %d", http.StatusOK, resp.StatusCode)
-		return nil, err
+	var deviceData []domain.DeviceData
+	deviceData, err = i.getDeviceData(ctx, devicedataUrl)
+	if err == nil && !labelsMatch(sensorLabels, deviceData) {
+		i.setupCache.invalidate(uniqueId)
 	}
 
-	respBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		err = fmt.Errorf("failed to read response body as bytes: %s", err.Error())
-		return nil, err
+	return deviceData, err
+}
+
+func (i *integrationAcoem) getDeviceData(ctx context.Context, devicedataUrl string) ([]domain.DeviceData, error) {
+	deviceData := []domain.DeviceData{}
+
+	headers := map[string]string{
+		//"TimeConvention": "TimeBeginning",
+		"TimeConvention": "TimeEnding", //The time convention for the request
 	}
 
-	err = json.Unmarshal(respBytes, &deviceData)
+	err := i.get(ctx, EndpointDeviceData, devicedataUrl, headers, &deviceData)
 	if err != nil {
-		err = fmt.Errorf("failed to unmarshal json data: %s", err.Error())
 		return nil, err
 	}
 
@@ -172,46 +206,68 @@ func (i *integrationAcoem) GetDevices(ctx context.Context) ([]domain.Device, err
 
 	devices := []domain.Device{}
 
-	httpClient := http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	err = i.get(ctx, EndpointDevices, fmt.Sprintf("%s/devices", i.baseUrl), nil, &devices)
+	if err != nil {
+		return nil, err
 	}
 
-	var req *http.Request
-	req, err = http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/devices", i.baseUrl), nil)
+	return devices, nil
+}
+
+// get sends a GET request to an Acoem endpoint and unmarshals the JSON response into
+// result. Any failure is returned as a *RequestError, except that the error of ctx is
+// returned as is if ctx is cancelled or times out, since that says nothing about Acoem.
+func (i *integrationAcoem) get(ctx context.Context, endpoint, url string, headers map[string]string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		err = fmt.Errorf("failed to create request: %s", err.Error())
-		return nil, err
+		return newRequestError(endpoint, 0, nil, fmt.Errorf("failed to create request: %w", err))
 	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Authorization", i.accessToken)
 
-	var response *http.Response
-	response, err = httpClient.Do(req)
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+
+	resp, err := i.httpClient.Do(req)
 	if err != nil {
-		err = fmt.Errorf("failed to retrieve list of devices: %s", err.Error())
-		return nil, err
+		if ctx.Err() != nil {
+			i.countRequest(ctx, endpoint, "cancelled")
+			return ctx.Err()
+		}
+		i.countRequest(ctx, endpoint, "error")
+		return newRequestError(endpoint, 0, ErrUpstreamUnavailable, fmt.Errorf("request failed: %w", err))
 	}
 
-	defer response.Body.Close()
+	i.countRequest(ctx, endpoint, strconv.Itoa(resp.StatusCode))
+
+	defer resp.Body.Close()
 
-	var responseBytes []byte
-	responseBytes, err = io.ReadAll(response.Body)
+	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		err = fmt.Errorf("failed to read response body as bytes: %s", err.Error())
-		return nil, err
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return newRequestError(endpoint, resp.StatusCode, ErrUpstreamUnavailable, fmt.Errorf("failed to read response body: %w", err))
 	}
 
-	if response.StatusCode != http.StatusOK {
-		err = fmt.Errorf("request failed, expected status code %d, got %d", http.StatusOK, response.StatusCode)
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		return newRequestError(endpoint, resp.StatusCode, errorFromStatusCode(resp.StatusCode),
+			fmt.Errorf("expected status code %d, got %d", http.StatusOK, resp.StatusCode))
 	}
 
-	err = json.Unmarshal(responseBytes, &devices)
+	err = json.Unmarshal(respBytes, result)
 	if err != nil {
-		err = fmt.Errorf("failed to unmarshal response: %s,\ndue to: %s", string(responseBytes), err.Error())
-		return nil, err
+		return newRequestError(endpoint, resp.StatusCode, ErrMalformedPayload, fmt.Errorf("failed to unmarshal response: %w", err))
 	}
 
-	return devices, nil
+	return nil
+}
+
+func (i *integrationAcoem) countRequest(ctx context.Context, endpoint, status string) {
+	i.requests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("endpoint", endpoint),
+		attribute.String("status", status),
+	))
 }
@@ -3,8 +3,10 @@ package application
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/diwise/integration-acoem/domain"
 	testutils "github.com/diwise/service-chassis/pkg/test/http"
@@ -16,6 +18,7 @@ import (
 var Expects = testutils.Expects
 var Returns = testutils.Returns
 var method = expects.RequestMethod
+var path = expects.RequestPath
 
 func TestThatGetDevicesFailsIfResponseCodeIsNotOK(t *testing.T) {
 	is := is.New(t)
@@ -36,6 +39,7 @@ func TestThatGetDevicesFailsIfResponseCodeIsNotOK(t *testing.T) {
 	stn, err := mockApp.GetDevices(context.Background())
 	is.True(err != nil)
 	is.True(stn == nil)
+	is.True(errors.Is(err, ErrNotFound))
 }
 
 func TestThatGetDevicesFailsIfReturnedDeviceDataIsIncorrect(t *testing.T) {
@@ -57,6 +61,64 @@ func TestThatGetDevicesFailsIfReturnedDeviceDataIsIncorrect(t *testing.T) {
 
 	is.True(err != nil)
 	is.True(dev == nil)
+	is.True(errors.Is(err, ErrMalformedPayload))
+}
+
+func TestThatGetDevicesReturnsDeviceMetadata(t *testing.T) {
+	is := is.New(t)
+
+	s := testutils.NewMockServiceThat(
+		Expects(
+			is,
+			method(http.MethodGet),
+			path("/devices"),
+		),
+		Returns(
+			response.Code(http.StatusOK),
+			response.Body([]byte(devicesResponse)),
+		),
+	)
+
+	mockApp := newMockApp(t, s.URL())
+	devices, err := mockApp.GetDevices(context.Background())
+	is.NoErr(err)
+
+	is.Equal([]domain.Device{{
+		UniqueId:       888100,
+		DeviceName:     "Sundsvall Kyrkogatan",
+		DeviceType:     "Gen2 Logger",
+		SerialNumber:   1336,
+		Firmware:       "1.138",
+		Customer:       "Sundsvall",
+		LastConnection: "2023-08-28T00:23:42+00:00",
+		Latitude:       62.388618,
+		Longitude:      17.308968,
+	}}, devices)
+}
+
+func TestThatGetDevicesReturnsUnauthorizedRequestError(t *testing.T) {
+	is := is.New(t)
+
+	s := testutils.NewMockServiceThat(
+		Expects(
+			is,
+			method(http.MethodGet),
+		),
+		Returns(
+			response.Code(http.StatusUnauthorized),
+			response.Body([]byte("")),
+		),
+	)
+
+	mockApp := newMockApp(t, s.URL())
+	_, err := mockApp.GetDevices(context.Background())
+
+	is.True(errors.Is(err, ErrUnauthorized))
+
+	var reqErr *RequestError
+	is.True(errors.As(err, &reqErr))
+	is.Equal(EndpointDevices, reqErr.Endpoint)
+	is.Equal(http.StatusUnauthorized, reqErr.StatusCode)
 }
 
 func TestGetDeviceDataFailsOnEmptyDeviceData(t *testing.T) {
@@ -78,6 +140,49 @@ func TestGetDeviceDataFailsOnEmptyDeviceData(t *testing.T) {
 	is.True(err != nil)
 }
 
+func TestThatGetDeviceDataRejectsInvalidArguments(t *testing.T) {
+	is := is.New(t)
+
+	mockApp := newMockApp(t, "http://localhost")
+
+	_, err := mockApp.GetDeviceData(context.Background(), 0, "s")
+	is.True(errors.Is(err, ErrInvalidArgument))
+
+	now := time.Now()
+	_, err = mockApp.GetDeviceDataRange(context.Background(), 123, "s", now, now)
+	is.True(errors.Is(err, ErrInvalidArgument))
+}
+
+func TestThatCancellationIsNotReportedAsUpstreamUnavailable(t *testing.T) {
+	is := is.New(t)
+
+	s := testutils.NewMockServiceThat(
+		Expects(
+			is,
+			method(http.MethodGet),
+		),
+		Returns(
+			response.Code(http.StatusOK),
+			response.Body([]byte(devicesResponse)),
+		),
+	)
+	mockApp := newMockApp(t, s.URL())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := mockApp.GetDevices(ctx)
+	is.True(errors.Is(err, context.Canceled))
+	is.True(!errors.Is(err, ErrUpstreamUnavailable))
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	_, err = mockApp.GetDeviceData(ctx, 123, "s")
+	is.True(errors.Is(err, context.DeadlineExceeded))
+	is.True(!errors.Is(err, ErrUpstreamUnavailable))
+}
+
 func TestThatGetDeviceDataFailsIfResponseCodeIsNotOK(t *testing.T) {
 	is := is.New(t)
 
@@ -128,17 +233,98 @@ func TestThatGetSensorDataReturnsAndMarshalsCorrectly(t *testing.T) {
 	data, err := json.Marshal(result)
 	is.NoErr(err)
 
-	expectation := `[{"timestamp":{"convention":"TimeBeginning","timestamp":"2023-08-27T22:08:00+00:00"},"location":{"altitude":0,"longitude":17.308968,"latitude":62.388618},"channels":[{"sensorName":"Nitrogen Dioxide","sensorLabel":"NO2","channel":11,"preScaled":{"reading":3.888},"scaled":{"reading":3.888},"unitName":"Parts Per Billion","slope":1,"offset":0,"flags":null},{"sensorName":"Nitrogen Oxides","sensorLabel":"NOx","channel":12,"preScaled":{"reading":5.421},"scaled":{"reading":5.421},"unitName":"Parts Per Billion","slope":1,"offset":0,"flags":null}]}]`
+	expectation := `[{"timestamp":{"convention":"TimeBeginning","timestamp":"2023-08-27T22:08:00+00:00"},"location":{"altitude":0,"longitude":17.308968,"latitude":62.388618},"channels":[{"sensorName":"Nitrogen Dioxide","sensorLabel":"NO2","channel":11,"uniqueId":888100,"preScaled":{"reading":3.888,"validPercentage":100,"flags":null},"scaled":{"reading":3.888,"validPercentage":100,"flags":null},"redactedPercentage":0,"unitName":"Parts Per Billion","slope":1,"offset":0,"dataRate":60,"flags":null},{"sensorName":"Nitrogen Oxides","sensorLabel":"NOx","channel":12,"uniqueId":888100,"preScaled":{"reading":5.421,"validPercentage":100,"flags":null},"scaled":{"reading":5.421,"validPercentage":100,"flags":null},"redactedPercentage":0,"unitName":"Parts Per Billion","slope":1,"offset":0,"dataRate":60,"flags":null}]}]`
 	is.Equal(expectation, string(data))
 }
 
+func TestThatGetDeviceDataRangeRequestsTimeRange(t *testing.T) {
+	is := is.New(t)
+
+	s := testutils.NewMockServiceThat(
+		Expects(
+			is,
+			method(http.MethodGet),
+			path("/devicedata/123/2023-08-27T22:00:00/2023-08-28T22:00:00/AVG300/data/NO2+NOx"),
+		),
+		Returns(
+			response.Code(http.StatusOK),
+			response.Body([]byte(deviceDataResponse)),
+		),
+	)
+	mockApp := newMockApp(t, s.URL())
+
+	from := time.Date(2023, 8, 27, 22, 0, 0, 0, time.UTC)
+
+	result, err := mockApp.GetDeviceDataRange(context.Background(), 123, "NO2+NOx", from, from.Add(24*time.Hour))
+	is.NoErr(err)
+	is.Equal(1, len(result))
+}
+
+func TestThatGetDeviceDataUsesConfiguredAveragingPeriodAndRecords(t *testing.T) {
+	is := is.New(t)
+
+	s := testutils.NewMockServiceThat(
+		Expects(
+			is,
+			method(http.MethodGet),
+			path("/devicedata/123/latest/12/AVG3600/datadiagnostic/NO2+NOx"),
+		),
+		Returns(
+			response.Code(http.StatusOK),
+			response.Body([]byte(deviceDataResponse)),
+		),
+	)
+
+	app, err := New(s.URL(), "user", "pass",
+		WithAveragingPeriod("AVERAGE3600"),
+		WithNumberOfRecords(12),
+		WithDataType("DataDiagnostic"),
+	)
+	is.NoErr(err)
+
+	_, err = app.GetDeviceData(context.Background(), 123, "NO2+NOx")
+	is.NoErr(err)
+}
+
+func TestThatNewFailsOnInvalidOptions(t *testing.T) {
+	is := is.New(t)
+
+	_, err := New("http://localhost", "user", "pass", WithAveragingPeriod("AVG400"))
+	is.True(err != nil)
+
+	_, err = New("http://localhost", "user", "pass", WithNumberOfRecords(0))
+	is.True(err != nil)
+
+	_, err = New("http://localhost", "user", "pass", WithDataType("raw"))
+	is.True(err != nil)
+}
+
 func newMockApp(t *testing.T, serverURL string) *integrationAcoem {
-	app := New(serverURL, "user", "pass")
+	app, err := New(serverURL, "user", "pass")
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err.Error())
+	}
 	mockApp := app.(*integrationAcoem)
 
 	return mockApp
 }
 
+const devicesResponse string = `[
+	{
+	  "Altitude": null,
+	  "Customer": "Sundsvall",
+	  "DeviceName": "Sundsvall Kyrkogatan",
+	  "DeviceType": "Gen2 Logger",
+	  "Firmware": "1.138",
+	  "Imsi": null,
+	  "LastConnection": "2023-08-28T00:23:42+00:00",
+	  "Latitude": 62.388618,
+	  "Longitude": 17.308968,
+	  "SerialNumber": 1336,
+	  "UniqueId": 888100
+	}
+  ]`
+
 const devicesBadResponse string = `[
 	{
 	  "Altitude": null,
@@ -0,0 +1,109 @@
+package application
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-acoem/domain"
+)
+
+// WithSetupCacheTTL sets how long the sensor labels from a device setup are cached before
+// they are requested from Acoem again. A TTL of zero disables the cache.
+func WithSetupCacheTTL(ttl time.Duration) Option {
+	return func(i *integrationAcoem) error {
+		if ttl < 0 {
+			return fmt.Errorf("setup cache TTL cannot be negative, got %s", ttl)
+		}
+
+		i.setupCache = newSetupCache(ttl)
+		return nil
+	}
+}
+
+type setupCacheEntry struct {
+	labels  string
+	expires time.Time
+}
+
+// setupCache holds the sensor labels of each device, since the sensor configuration
+// of a device rarely changes between polls
+type setupCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int]setupCacheEntry
+}
+
+func newSetupCache(ttl time.Duration) *setupCache {
+	return &setupCache{
+		ttl:     ttl,
+		entries: map[int]setupCacheEntry{},
+	}
+}
+
+func (c *setupCache) get(deviceID int, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[deviceID]
+	if !ok || !now.Before(entry.expires) {
+		return "", false
+	}
+
+	return entry.labels, true
+}
+
+func (c *setupCache) set(deviceID int, labels string, now time.Time) {
+	if c.ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[deviceID] = setupCacheEntry{
+		labels:  labels,
+		expires: now.Add(c.ttl),
+	}
+}
+
+func (c *setupCache) invalidate(deviceID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, deviceID)
+}
+
+// labelsMatch reports whether the channels in the device data are exactly the ones
+// that were requested using sensorLabels
+func labelsMatch(sensorLabels string, data []domain.DeviceData) bool {
+	if len(data) == 0 {
+		// nothing was returned for the period, which says nothing about the setup
+		return true
+	}
+
+	requested := strings.Split(sensorLabels, "+")
+
+	received := []string{}
+	for _, d := range data {
+		for _, c := range d.Channels {
+			if !slices.Contains(received, c.SensorLabel) {
+				received = append(received, c.SensorLabel)
+			}
+		}
+	}
+
+	if len(received) != len(requested) {
+		return false
+	}
+
+	for _, label := range requested {
+		if !slices.ContainsFunc(received, func(r string) bool { return strings.EqualFold(r, label) }) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,85 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	testutils "github.com/diwise/service-chassis/pkg/test/http"
+	"github.com/diwise/service-chassis/pkg/test/http/response"
+	"github.com/matryer/is"
+)
+
+func TestThatSensorLabelsAreCached(t *testing.T) {
+	is := is.New(t)
+
+	s := testutils.NewMockServiceThat(
+		Expects(
+			is,
+			method(http.MethodGet),
+			path("/devices/setup/888100"),
+		),
+		Returns(
+			response.Code(http.StatusOK),
+			response.Body([]byte(deviceSetupResponse)),
+		),
+	)
+	mockApp := newMockApp(t, s.URL())
+
+	labels, err := mockApp.GetSensorLabels(context.Background(), 888100)
+	is.NoErr(err)
+	is.Equal("NO2+NOx", labels)
+
+	labels, err = mockApp.GetSensorLabels(context.Background(), 888100)
+	is.NoErr(err)
+	is.Equal("NO2+NOx", labels)
+
+	is.Equal(1, s.RequestCount())
+}
+
+func TestThatCachedSensorLabelsAreInvalidatedWhenChannelsDiffer(t *testing.T) {
+	is := is.New(t)
+
+	mockApp := newMockApp(t, "http://localhost")
+	now := time.Now()
+
+	mockApp.setupCache.set(888100, "NO2+NOx+PM10", now)
+
+	s := testutils.NewMockServiceThat(
+		Expects(is, method(http.MethodGet)),
+		Returns(
+			response.Code(http.StatusOK),
+			response.Body([]byte(deviceDataResponse)),
+		),
+	)
+	mockApp.baseUrl = s.URL()
+
+	_, err := mockApp.GetDeviceData(context.Background(), 888100, "NO2+NOx+PM10")
+	is.NoErr(err)
+
+	_, ok := mockApp.setupCache.get(888100, now)
+	is.True(!ok)
+}
+
+func TestThatSetupCacheEntriesExpire(t *testing.T) {
+	is := is.New(t)
+
+	c := newSetupCache(time.Minute)
+	now := time.Now()
+
+	c.set(1, "PM10", now)
+
+	_, ok := c.get(1, now.Add(59*time.Second))
+	is.True(ok)
+
+	_, ok = c.get(1, now.Add(time.Minute))
+	is.True(!ok)
+}
+
+const deviceSetupResponse string = `[
+	{"Active": true, "SensorLabel": "NO2", "Type": "data"},
+	{"Active": true, "SensorLabel": "NOx", "Type": "data"},
+	{"Active": false, "SensorLabel": "PM10", "Type": "data"},
+	{"Active": true, "SensorLabel": "BATT", "Type": "diagnostic"}
+]`
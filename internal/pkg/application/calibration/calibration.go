@@ -0,0 +1,237 @@
+package calibration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// Table holds the local calibrations of device sensors
+type Table struct {
+	Calibrations []Calibration `yaml:"calibrations"`
+}
+
+// Calibration corrects the readings of a sensor on one device, or on every device if DeviceID
+// is zero. Exactly one of Linear, Polynomial and Humidity must be set.
+type Calibration struct {
+	DeviceID int    `yaml:"deviceID"`
+	Sensor   string `yaml:"sensor"`  // the sensor name or label, ignoring case
+	Version  string `yaml:"version"` // published along with the calibrated value
+	Input    string `yaml:"input"`   // the reading that is corrected, scaled (default) or prescaled
+
+	Linear     *Linear   `yaml:"linear"`
+	Polynomial []float64 `yaml:"polynomial"` // coefficients in increasing order, c0 + c1·x + c2·x² ...
+	Humidity   *Humidity `yaml:"humidity"`
+}
+
+// Linear corrects a reading x as slope·x + offset
+type Linear struct {
+	Slope  float64 `yaml:"slope"`
+	Offset float64 `yaml:"offset"`
+}
+
+// Humidity corrects particulate matter for the water taken up by particles in humid air, using
+// the κ-Köhler growth factor x / (1 + (κ/1.65) / (100/RH - 1)) with the relative humidity
+// reported by the same device
+type Humidity struct {
+	Kappa float64 `yaml:"kappa"`
+}
+
+// maxHumidity limits the correction, which grows without bound as the air is saturated
+const maxHumidity float64 = 95
+
+// Load reads a calibration table in YAML or JSON format
+func Load(r io.Reader) (Table, error) {
+	t := Table{}
+
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(&t)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Table{}, fmt.Errorf("failed to parse calibrations: %w", err)
+	}
+
+	err = t.Validate()
+	if err != nil {
+		return Table{}, err
+	}
+
+	return t, nil
+}
+
+// Validate returns an error describing every problem found in the table
+func (t Table) Validate() error {
+	errs := []error{}
+	seen := map[string]bool{}
+
+	for idx, c := range t.Calibrations {
+		if c.Sensor == "" || c.Version == "" {
+			errs = append(errs, fmt.Errorf("calibration %d: both sensor and version are required", idx))
+			continue
+		}
+
+		name := fmt.Sprintf("%s on device %d", c.Sensor, c.DeviceID)
+
+		key := fmt.Sprintf("%d/%s", c.DeviceID, strings.ToLower(c.Sensor))
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("calibration of %s: defined more than once", name))
+		}
+		seen[key] = true
+
+		if c.Input != "" && c.Input != string(domain.ReadingScaled) && c.Input != string(domain.ReadingPreScaled) {
+			errs = append(errs, fmt.Errorf("calibration of %s: invalid input %q, expected scaled or prescaled", name, c.Input))
+		}
+
+		methods := 0
+		if c.Linear != nil {
+			methods++
+		}
+		if len(c.Polynomial) > 0 {
+			methods++
+		}
+		if c.Humidity != nil {
+			methods++
+			if c.Humidity.Kappa < 0 {
+				errs = append(errs, fmt.Errorf("calibration of %s: kappa must not be negative", name))
+			}
+		}
+
+		if methods != 1 {
+			errs = append(errs, fmt.Errorf("calibration of %s: exactly one of linear, polynomial and humidity must be set", name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid calibrations: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// find returns the calibration of a channel on a device, preferring a calibration of the
+// device over one that applies to every device
+func (t Table) find(deviceID int, ch domain.Channel) (Calibration, bool) {
+	matches := func(id int) func(Calibration) bool {
+		return func(c Calibration) bool {
+			return c.DeviceID == id && (strings.EqualFold(c.Sensor, ch.SensorName) || (ch.SensorLabel != "" && strings.EqualFold(c.Sensor, ch.SensorLabel)))
+		}
+	}
+
+	for _, id := range []int{deviceID, 0} {
+		if idx := slices.IndexFunc(t.Calibrations, matches(id)); idx >= 0 {
+			return t.Calibrations[idx], true
+		}
+	}
+
+	return Calibration{}, false
+}
+
+// apply returns the calibrated value of x, given the relative humidity if known
+func (c Calibration) apply(x float64, humidity float64, humidityKnown bool) (float64, error) {
+	switch {
+	case c.Linear != nil:
+		return c.Linear.Slope*x + c.Linear.Offset, nil
+	case len(c.Polynomial) > 0:
+		y := 0.0
+		for i := len(c.Polynomial) - 1; i >= 0; i-- {
+			y = y*x + c.Polynomial[i]
+		}
+		return y, nil
+	case c.Humidity != nil:
+		if !humidityKnown {
+			return 0, errors.New("relative humidity is not reported by the device")
+		}
+		rh := math.Min(math.Max(humidity, 0), maxHumidity)
+		if rh == 0 {
+			return x, nil
+		}
+		return x / (1 + (c.Humidity.Kappa/1.65)/(100/rh-1)), nil
+	}
+
+	return 0, errors.New("no calibration method")
+}
+
+type processor struct {
+	table     Table
+	catalogue *domain.Catalogue
+	reading   domain.ReadingSelection
+}
+
+// NewProcessor returns a stage that replaces the published reading of each channel that has
+// a calibration with the calibrated value, and records the version of the calibration. The
+// catalogue is used to find the relative humidity reported by a device.
+func NewProcessor(table Table, catalogue *domain.Catalogue, reading domain.ReadingSelection) application.Processor {
+	return &processor{table: table, catalogue: catalogue, reading: reading}
+}
+
+func (p *processor) Process(ctx context.Context, device domain.Device, data []domain.DeviceData) []domain.DeviceData {
+	if len(p.table.Calibrations) == 0 {
+		return data
+	}
+
+	logger := logging.GetFromContext(ctx)
+
+	result := make([]domain.DeviceData, 0, len(data))
+
+	for _, d := range data {
+		humidity, humidityKnown := p.humidityOf(d.Channels)
+
+		d.Channels = slices.Clone(d.Channels)
+
+		for idx, ch := range d.Channels {
+			c, ok := p.table.find(device.UniqueId, ch)
+			if !ok {
+				continue
+			}
+
+			x := ch.Scaled.Reading
+			if c.Input == string(domain.ReadingPreScaled) {
+				x = ch.PreScaled.Reading
+			}
+
+			y, err := c.apply(x, humidity, humidityKnown)
+			if err != nil {
+				logger.Warn("could not calibrate reading", "device_id", device.UniqueId, "sensor_name", ch.SensorName, "version", c.Version, "err", err.Error())
+				continue
+			}
+
+			if p.reading == domain.ReadingPreScaled {
+				d.Channels[idx].PreScaled.Reading = y
+			} else {
+				d.Channels[idx].Scaled.Reading = y
+			}
+			d.Channels[idx].Calibration = c.Version
+		}
+
+		result = append(result, d)
+	}
+
+	return result
+}
+
+// humidityOf returns the relative humidity in percent among the channels, if reported
+func (p *processor) humidityOf(channels []domain.Channel) (float64, bool) {
+	for _, ch := range channels {
+		m, ok := p.catalogue.Lookup(ch)
+		if !ok || m.Quantity != "relativeHumidity" {
+			continue
+		}
+
+		rh, err := p.catalogue.Convert(ch, m, ch.Scaled.Reading, "%", domain.ReferenceConditions)
+		if err == nil {
+			return rh, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,145 @@
+package calibration
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/matryer/is"
+)
+
+const table string = `
+calibrations:
+  - sensor: PM10
+    version: pm10-all-v1
+    linear: {slope: 0.5, offset: 1}
+  - deviceID: 888100
+    sensor: Particulate Matter (PM 10)
+    version: pm10-888100-v2
+    input: prescaled
+    linear: {slope: 2, offset: -1}
+  - deviceID: 888100
+    sensor: nitrogen dioxide
+    version: no2-v1
+    polynomial: [1, 2, 0.5]
+  - sensor: PM2.5
+    version: pm25-v1
+    humidity: {kappa: 0.33}
+`
+
+func TestLinearCalibrationPrefersTheDevice(t *testing.T) {
+	is := is.New(t)
+
+	p := newProcessor(is)
+
+	ch := newChannel("Particulate Matter (PM 10)", "PM10", 10)
+	ch.PreScaled.Reading = 8
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 888100}, []domain.DeviceData{{Channels: []domain.Channel{ch}}})
+	is.Equal(15.0, result[0].Channels[0].Scaled.Reading) // 2·8 - 1 using the prescaled reading
+	is.Equal("pm10-888100-v2", result[0].Channels[0].Calibration)
+
+	result = p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{{Channels: []domain.Channel{ch}}})
+	is.Equal(6.0, result[0].Channels[0].Scaled.Reading) // 0.5·10 + 1 matched by sensor label
+	is.Equal("pm10-all-v1", result[0].Channels[0].Calibration)
+
+	is.Equal(10.0, ch.Scaled.Reading) // the input is left unchanged
+}
+
+func TestCalibrationReplacesThePrescaledReadingWhenItIsPublished(t *testing.T) {
+	is := is.New(t)
+
+	tbl, err := Load(strings.NewReader(table))
+	is.NoErr(err)
+
+	p := NewProcessor(tbl, domain.DefaultCatalogue(), domain.ReadingPreScaled)
+
+	ch := newChannel("Particulate Matter (PM 10)", "PM10", 10)
+	ch.PreScaled.Reading = 8
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 888100}, []domain.DeviceData{{Channels: []domain.Channel{ch}}})
+	is.Equal(15.0, result[0].Channels[0].PreScaled.Reading) // 2·8 - 1
+	is.Equal(10.0, result[0].Channels[0].Scaled.Reading)
+	is.Equal("pm10-888100-v2", result[0].Channels[0].Calibration)
+
+	result = p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{{Channels: []domain.Channel{ch}}})
+	is.Equal(6.0, result[0].Channels[0].PreScaled.Reading) // 0.5·10 + 1 using the scaled reading
+	is.Equal("pm10-all-v1", result[0].Channels[0].Calibration)
+}
+
+func TestPolynomialCalibration(t *testing.T) {
+	is := is.New(t)
+
+	p := newProcessor(is)
+
+	data := []domain.DeviceData{{Channels: []domain.Channel{
+		newChannel("Nitrogen Dioxide", "NO2", 4),
+		newChannel("Ozone", "O3", 4),
+	}}}
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 888100}, data)
+	is.Equal(17.0, result[0].Channels[0].Scaled.Reading) // 1 + 2·4 + 0.5·4²
+	is.Equal(4.0, result[0].Channels[1].Scaled.Reading)
+	is.Equal("", result[0].Channels[1].Calibration)
+}
+
+func TestHumidityCalibrationUsesTheHumidityOfTheDevice(t *testing.T) {
+	is := is.New(t)
+
+	p := newProcessor(is)
+
+	humidity := newChannel("Humidity", "HUM", 75)
+	humidity.UnitName = "Percent"
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{{Channels: []domain.Channel{
+		newChannel("Particulate Matter (PM 2.5)", "PM2.5", 20), humidity,
+	}}})
+
+	expected := 20 / (1 + (0.33/1.65)/(100.0/75-1))
+	is.True(math.Abs(expected-result[0].Channels[0].Scaled.Reading) < 1e-9)
+	is.Equal("pm25-v1", result[0].Channels[0].Calibration)
+
+	// without humidity the reading is published uncalibrated
+	result = p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{{Channels: []domain.Channel{
+		newChannel("Particulate Matter (PM 2.5)", "PM2.5", 20),
+	}}})
+	is.Equal(20.0, result[0].Channels[0].Scaled.Reading)
+	is.Equal("", result[0].Channels[0].Calibration)
+}
+
+func TestLoadRejectsInvalidCalibrations(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":   "calibrations:\n  - sensor: NO2\n    version: v1\n    lin: {slope: 1}\n",
+		"missing version": "calibrations:\n  - sensor: NO2\n    linear: {slope: 1}\n",
+		"no method":       "calibrations:\n  - sensor: NO2\n    version: v1\n",
+		"two methods":     "calibrations:\n  - sensor: NO2\n    version: v1\n    linear: {slope: 1}\n    polynomial: [0, 1]\n",
+		"duplicate":       "calibrations:\n  - sensor: NO2\n    version: v1\n    linear: {slope: 1}\n  - sensor: no2\n    version: v2\n    linear: {slope: 2}\n",
+		"invalid input":   "calibrations:\n  - sensor: NO2\n    version: v1\n    input: raw\n    linear: {slope: 1}\n",
+		"negative kappa":  "calibrations:\n  - sensor: PM10\n    version: v1\n    humidity: {kappa: -1}\n",
+	}
+
+	for name, file := range tests {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := Load(strings.NewReader(file))
+			is.True(err != nil)
+		})
+	}
+}
+
+func newProcessor(is *is.I) *processor {
+	t, err := Load(strings.NewReader(table))
+	is.NoErr(err)
+
+	return NewProcessor(t, domain.DefaultCatalogue(), domain.ReadingScaled).(*processor)
+}
+
+func newChannel(sensorName, sensorLabel string, reading float64) domain.Channel {
+	ch := domain.Channel{SensorName: sensorName, SensorLabel: sensorLabel}
+	ch.Scaled.Reading = reading
+	ch.PreScaled.Reading = reading
+	return ch
+}
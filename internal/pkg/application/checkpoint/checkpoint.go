@@ -0,0 +1,160 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store keeps track of the timestamp of the last device data that was successfully
+// delivered to an output, so that polling can resume from there after a restart
+type Store interface {
+	Get(ctx context.Context, deviceID int, output string) (time.Time, bool, error)
+	Set(ctx context.Context, deviceID int, output string, timestamp time.Time) error
+}
+
+type fileStore struct {
+	mu          sync.Mutex
+	path        string
+	checkpoints map[string]map[string]time.Time
+}
+
+// NewFileStore returns a Store that persists checkpoints as JSON in the file at path.
+// Existing checkpoints are loaded if the file exists, otherwise it is created on the
+// first call to Set.
+func NewFileStore(path string) (Store, error) {
+	s := &fileStore{
+		path:        path,
+		checkpoints: map[string]map[string]time.Time{},
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read checkpoint file: %w", err)
+	}
+
+	if len(b) == 0 {
+		return s, nil
+	}
+
+	err = json.Unmarshal(b, &s.checkpoints)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint file %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *fileStore) Get(ctx context.Context, deviceID int, output string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	outputs, ok := s.checkpoints[strconv.Itoa(deviceID)]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	timestamp, ok := outputs[output]
+	return timestamp, ok, nil
+}
+
+func (s *fileStore) Set(ctx context.Context, deviceID int, output string, timestamp time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strconv.Itoa(deviceID)
+
+	if _, ok := s.checkpoints[key]; !ok {
+		s.checkpoints[key] = map[string]time.Time{}
+	}
+	s.checkpoints[key][output] = timestamp.UTC()
+
+	return s.save()
+}
+
+// save writes all checkpoints to a temporary file that is synced to disk and then
+// renamed over the previous one, so that a crash never leaves a partially written file behind
+func (s *fileStore) save() error {
+	b, err := json.MarshalIndent(s.checkpoints, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoints: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary checkpoint file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoints: %w", err)
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync checkpoints: %w", err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoints: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		return fmt.Errorf("failed to replace checkpoint file: %w", err)
+	}
+
+	return nil
+}
+
+type memoryStore struct {
+	mu          sync.Mutex
+	checkpoints map[int]map[string]time.Time
+}
+
+// NewMemoryStore returns a Store that only keeps checkpoints for the lifetime of the process
+func NewMemoryStore() Store {
+	return &memoryStore{
+		checkpoints: map[int]map[string]time.Time{},
+	}
+}
+
+func (s *memoryStore) Get(ctx context.Context, deviceID int, output string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timestamp, ok := s.checkpoints[deviceID][output]
+	return timestamp, ok, nil
+}
+
+func (s *memoryStore) Set(ctx context.Context, deviceID int, output string, timestamp time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.checkpoints[deviceID]; !ok {
+		s.checkpoints[deviceID] = map[string]time.Time{}
+	}
+	s.checkpoints[deviceID][output] = timestamp.UTC()
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package checkpoint
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatCheckpointsArePersistedBetweenStores(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "data", "checkpoints.json")
+	timestamp := time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC)
+
+	store, err := NewFileStore(path)
+	is.NoErr(err)
+
+	_, ok, err := store.Get(ctx, 888100, "fiware")
+	is.NoErr(err)
+	is.True(!ok)
+
+	is.NoErr(store.Set(ctx, 888100, "fiware", timestamp))
+
+	store, err = NewFileStore(path)
+	is.NoErr(err)
+
+	stored, ok, err := store.Get(ctx, 888100, "fiware")
+	is.NoErr(err)
+	is.True(ok)
+	is.True(timestamp.Equal(stored))
+
+	_, ok, err = store.Get(ctx, 888100, "lwm2m")
+	is.NoErr(err)
+	is.True(!ok)
+}
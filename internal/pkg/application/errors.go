@@ -0,0 +1,81 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	EndpointDevices     string = "devices"
+	EndpointDeviceSetup string = "devices/setup"
+	EndpointDeviceData  string = "devicedata"
+)
+
+var (
+	// ErrUnauthorized is returned when Acoem rejects the account ID or key
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound is returned when a device, or its data, does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrRateLimited is returned when the account quota has been exceeded, even after retrying
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUpstreamUnavailable is returned when Acoem could not be reached or failed to handle
+	// the request, even after retrying
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	// ErrMalformedPayload is returned when the response from Acoem could not be parsed
+	ErrMalformedPayload = errors.New("malformed payload")
+	// ErrInvalidArgument is returned when a request cannot be sent due to invalid arguments
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// RequestError describes a failed request to one of the Acoem endpoints. It matches one of
+// the sentinel errors above, if the failure could be classified, as well as its cause.
+type RequestError struct {
+	Endpoint   string
+	StatusCode int // zero if no response was received
+	Kind       error
+	Err        error
+}
+
+func newRequestError(endpoint string, statusCode int, kind, err error) *RequestError {
+	return &RequestError{
+		Endpoint:   endpoint,
+		StatusCode: statusCode,
+		Kind:       kind,
+		Err:        err,
+	}
+}
+
+func (e *RequestError) Error() string {
+	msg := fmt.Sprintf("request to %s failed", e.Endpoint)
+
+	if e.Kind != nil {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Kind.Error())
+	}
+
+	return fmt.Sprintf("%s: %s", msg, e.Err.Error())
+}
+
+func (e *RequestError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// errorFromStatusCode classifies an unexpected status code, returning nil if it does
+// not correspond to any of the sentinel errors
+func errorFromStatusCode(statusCode int) error {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case statusCode == http.StatusNotFound:
+		return ErrNotFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode >= http.StatusInternalServerError:
+		return ErrUpstreamUnavailable
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package fiware
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strconv"
 
 	fw "github.com/diwise/context-broker/pkg/datamodels/fiware"
@@ -13,6 +14,7 @@ import (
 	. "github.com/diwise/context-broker/pkg/ngsild/types/entities/decorators"
 	"github.com/diwise/context-broker/pkg/ngsild/types/properties"
 	"github.com/diwise/integration-acoem/domain"
+	"github.com/diwise/integration-acoem/internal/pkg/application"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
@@ -21,7 +23,24 @@ import (
 
 var tracer = otel.Tracer("integration-acoem/fiware")
 
-func CreateOrUpdateAirQualityObserved(ctx context.Context, cbClient client.ContextBrokerClient, sensors []domain.DeviceData, deviceName string, uniqueId int) error {
+type Outcome string
+
+const (
+	OutcomeCreated Outcome = "created"
+	OutcomeUpdated Outcome = "updated"
+	OutcomeFailed  Outcome = "failed"
+)
+
+// Result describes what happened to the AirQualityObserved entity of a device
+type Result struct {
+	EntityID string
+	Outcome  Outcome
+}
+
+// CreateOrUpdateAirQualityObserved merges the sensor data into the AirQualityObserved entity
+// of the device, or creates the entity if it does not exist yet. The outcome is failed
+// whenever an error is returned.
+func CreateOrUpdateAirQualityObserved(ctx context.Context, cbClient client.ContextBrokerClient, catalogue *domain.Catalogue, reading domain.ReadingSelection, sensors []domain.DeviceData, deviceName string, uniqueId int) (Result, error) {
 	var err error
 
 	ctx, span := tracer.Start(ctx, "create-air-qualities")
@@ -41,84 +60,158 @@ func CreateOrUpdateAirQualityObserved(ctx context.Context, cbClient client.Conte
 			DateTime(properties.DateObserved, sensor.Timestamp.Timestamp),
 		)
 
-		sensorReadings := createFragmentsFromSensorData(sensor.Channels, sensor.Timestamp.Timestamp)
+		sensorReadings, dropped := createFragmentsFromSensorData(catalogue, reading, sensor.Channels, sensor.Timestamp.Timestamp)
+		application.CountChannels(ctx, "fiware", len(sensor.Channels), dropped)
 
 		decorators = append(decorators, sensorReadings...)
 	}
 
+	entityID := fw.AirQualityObservedIDPrefix + strconv.Itoa(uniqueId)
+	result := Result{EntityID: entityID, Outcome: OutcomeFailed}
+
 	var fragment types.EntityFragment
 	fragment, err = entities.NewFragment(decorators...)
 	if err != nil {
-		logger.Error("failed to create entity fragments", "err", err.Error())
+		err = fmt.Errorf("failed to create entity fragment for %s: %w", entityID, err)
+		return result, err
 	}
 
-	entityID := fw.AirQualityObservedIDPrefix + strconv.Itoa(uniqueId)
-
 	_, err = cbClient.MergeEntity(ctx, entityID, fragment, headers)
+	if err == nil {
+		countEntity(ctx, "merge", nil)
+		logger.Info("entity updated", "entity_id", entityID)
+		result.Outcome = OutcomeUpdated
+		return result, nil
+	}
+
+	if !errors.Is(err, ngsierrors.ErrNotFound) {
+		countEntity(ctx, "merge", err)
+		err = fmt.Errorf("failed to merge entity %s: %w", entityID, err)
+		return result, err
+	}
+
+	var entity types.Entity
+	entity, err = entities.New(entityID, fw.AirQualityObservedTypeName, decorators...)
 	if err != nil {
-		if !errors.Is(err, ngsierrors.ErrNotFound) {
-			logger.Error("failed to merge entity", "err", err.Error())
-		}
+		err = fmt.Errorf("failed to create new entity %s: %w", entityID, err)
+		return result, err
+	}
 
-		var entity types.Entity
-		entity, err = entities.New(entityID, fw.AirQualityObservedTypeName, decorators...)
-		if err != nil {
-			logger.Error("failed to create new entity", "err", err.Error())
+	_, err = cbClient.CreateEntity(ctx, entity, headers)
+	countEntity(ctx, "create", err)
+	if err != nil {
+		err = fmt.Errorf("failed to post entity %s to context broker: %w", entityID, err)
+		return result, err
+	}
+
+	logger.Info("entity created", "entity_id", entityID)
+	result.Outcome = OutcomeCreated
 
+	return result, nil
+}
+
+// createFragmentsFromSensorData returns a decorator for each channel that maps to a property
+// in the catalogue, with the selected reading converted to the unit of the measurement, along
+// with the sensor names of the channels that were dropped
+func createFragmentsFromSensorData(catalogue *domain.Catalogue, reading domain.ReadingSelection, sensors []domain.Channel, timestamp string) ([]entities.EntityDecoratorFunc, []string) {
+	readings := []entities.EntityDecoratorFunc{}
+	dropped := []string{}
+
+	conditions := catalogue.ConditionsOf(sensors)
+
+	for _, sensor := range sensors {
+		m, ok := catalogue.Lookup(sensor)
+		if !ok || m.Property == "" {
+			dropped = append(dropped, sensor.SensorName)
+			continue
 		}
 
-		_, err = cbClient.CreateEntity(ctx, entity, headers)
+		value, err := catalogue.Convert(sensor, m, reading.Value(sensor), m.Unit, conditions)
 		if err != nil {
-			logger.Error("failed to post entity to context broker", "err", err.Error())
+			dropped = append(dropped, sensor.SensorName)
+			continue
 		}
 
-		logger.Info("entity created", "entity_id", entityID)
-	} else {
-		logger.Info("entity updated", "entity_id", entityID)
-	}
+		unit, _ := catalogue.UnitByCode(m.Unit)
 
-	return nil
-}
+		number := properties.NewNumberProperty(value)
+		properties.UnitCode(unit.UnitCode)(number)
+		properties.ObservedAt(timestamp)(number)
 
-func createFragmentsFromSensorData(sensors []domain.Channel, timestamp string) []entities.EntityDecoratorFunc {
-	readings := []entities.EntityDecoratorFunc{}
+		var property types.Property = number
 
-	for _, sensor := range sensors {
-		name, ok := sensorNames[sensor.SensorName]
-		if ok {
-			readings = append(readings, Number(
-				name,
-				sensor.Scaled.Reading,
-				properties.UnitCode(unitCodes[sensor.UnitName]),
-				properties.ObservedAt(timestamp),
-			))
+		calibrated := sensor.Calibration != ""
+
+		if reading == domain.ReadingBoth || calibrated {
+			withSubProperties := &numberWithSubProperties{NumberProperty: number}
+
+			if reading == domain.ReadingBoth {
+				raw, err := catalogue.Convert(sensor, m, sensor.PreScaled.Reading, m.Unit, conditions)
+				if err != nil {
+					dropped = append(dropped, sensor.SensorName)
+					continue
+				}
+				withSubProperties.Raw = properties.NewNumberProperty(raw)
+			}
+
+			if calibrated {
+				withSubProperties.Calibration = properties.NewTextProperty(sensor.Calibration)
+			}
+
+			property = withSubProperties
+		}
+
+		readings = append(readings, entities.P(m.Property, property))
+
+		if len(sensor.Annotations) > 0 {
+			readings = append(readings, annotations(m.Property, sensor.Annotations, timestamp))
 		}
 	}
 
-	return readings
+	return readings, dropped
 }
 
-var unitCodes map[string]string = map[string]string{
-	"Micrograms Per Cubic Meter": "GQ",
-	"Volts":                      "VLT",
-	"Celsius":                    "CEL",
-	"Percent":                    "P1",
-	"Hectopascals":               "A97",
-	"Parts Per Billion":          "61",
-	"Pressure (mbar)":            "MBR",
+// annotations returns a decorator that publishes the data quality annotations of a property
+// as a text list named after it, such as NO2Quality. The annotations are observed at the same
+// time as the value they apply to, so that an annotation left over from an earlier value can
+// be told apart.
+func annotations(property string, values []string, timestamp string) entities.EntityDecoratorFunc {
+	p := properties.NewTextListProperty(values)
+	p.ObservedAt_ = &timestamp
+
+	return entities.P(property+"Quality", p)
+}
+
+// numberWithSubProperties is a number property with the prescaled reading as a sub-property
+// named raw, in the same unit as the property itself, and the version of the local calibration
+// applied to the value as a sub-property named calibration
+type numberWithSubProperties struct {
+	*properties.NumberProperty
+	Raw         *properties.NumberProperty `json:"raw,omitempty"`
+	Calibration *properties.TextProperty   `json:"calibration,omitempty"`
+}
+
+type sink struct {
+	cbClient  client.ContextBrokerClient
+	catalogue *domain.Catalogue
+	reading   domain.ReadingSelection
+}
+
+// NewSink returns a sink that creates or updates an AirQualityObserved entity per device
+// in the context broker, with a property per channel as mapped by the catalogue
+func NewSink(cbClient client.ContextBrokerClient, catalogue *domain.Catalogue, reading domain.ReadingSelection) application.Sink {
+	return &sink{cbClient: cbClient, catalogue: catalogue, reading: reading}
+}
+
+func (s *sink) Name() string {
+	return "fiware"
 }
 
-var sensorNames map[string]string = map[string]string{
-	"Humidity":                    "relativeHumidity",
-	"Temperature":                 "temperature",
-	"Air Pressure":                "atmosphericPressure",
-	"Particulate Matter (PM 1)":   "PM1",
-	"PM 4":                        "PM4",
-	"Particulate Matter (PM 10)":  "PM10",
-	"Particulate Matter (PM 2.5)": "PM25",
-	"Total Suspended Particulate": "totalSuspendedParticulate",
-	"Voltage":                     "voltage",
-	"Nitric Oxide":                "NO",
-	"Nitrogen Dioxide":            "NO2",
-	"Nitrogen Oxides":             "NOx",
+func (s *sink) Deliver(ctx context.Context, device domain.Device, data []domain.DeviceData) error {
+	_, err := CreateOrUpdateAirQualityObserved(ctx, s.cbClient, s.catalogue, s.reading, data, device.DeviceName, device.UniqueId)
+	return err
+}
+
+func (s *sink) Close() error {
+	return nil
 }
@@ -0,0 +1,197 @@
+package fiware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/diwise/context-broker/pkg/ngsild"
+	ngsierrors "github.com/diwise/context-broker/pkg/ngsild/errors"
+	"github.com/diwise/context-broker/pkg/ngsild/types"
+	test "github.com/diwise/context-broker/pkg/test"
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/matryer/is"
+)
+
+func TestThatExistingEntityIsUpdated(t *testing.T) {
+	is := is.New(t)
+
+	cbClient := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	result, err := CreateOrUpdateAirQualityObserved(context.Background(), cbClient, domain.DefaultCatalogue(), domain.ReadingScaled, testData(is), "abc", 888100)
+	is.NoErr(err)
+	is.Equal(Result{EntityID: "urn:ngsi-ld:AirQualityObserved:888100", Outcome: OutcomeUpdated}, result)
+	is.Equal(0, len(cbClient.CreateEntityCalls()))
+}
+
+func TestThatMissingEntityIsCreated(t *testing.T) {
+	is := is.New(t)
+
+	cbClient := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return nil, fmt.Errorf("merge failed: %w", ngsierrors.ErrNotFound)
+		},
+		CreateEntityFunc: func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+			return &ngsild.CreateEntityResult{}, nil
+		},
+	}
+
+	result, err := CreateOrUpdateAirQualityObserved(context.Background(), cbClient, domain.DefaultCatalogue(), domain.ReadingScaled, testData(is), "abc", 888100)
+	is.NoErr(err)
+	is.Equal(OutcomeCreated, result.Outcome)
+	is.Equal(1, len(cbClient.CreateEntityCalls()))
+}
+
+func TestThatMergeErrorIsReturnedWithoutCreatingEntity(t *testing.T) {
+	is := is.New(t)
+
+	mergeErr := errors.New("internal server error")
+
+	cbClient := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return nil, mergeErr
+		},
+	}
+
+	result, err := CreateOrUpdateAirQualityObserved(context.Background(), cbClient, domain.DefaultCatalogue(), domain.ReadingScaled, testData(is), "abc", 888100)
+	is.True(errors.Is(err, mergeErr))
+	is.Equal(OutcomeFailed, result.Outcome)
+	is.Equal(0, len(cbClient.CreateEntityCalls()))
+}
+
+func TestThatCreateErrorIsReturned(t *testing.T) {
+	is := is.New(t)
+
+	createErr := errors.New("bad request")
+
+	cbClient := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, fragment types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			return nil, ngsierrors.ErrNotFound
+		},
+		CreateEntityFunc: func(ctx context.Context, entity types.Entity, headers map[string][]string) (*ngsild.CreateEntityResult, error) {
+			return nil, createErr
+		},
+	}
+
+	result, err := CreateOrUpdateAirQualityObserved(context.Background(), cbClient, domain.DefaultCatalogue(), domain.ReadingScaled, testData(is), "abc", 888100)
+	is.True(errors.Is(err, createErr))
+	is.Equal(OutcomeFailed, result.Outcome)
+}
+
+func TestThatAnnotationsArePublishedWithTheProperty(t *testing.T) {
+	is := is.New(t)
+
+	data := testData(is)
+	data[0].Channels[0].Annotations = []string{"Calibration"}
+
+	var fragment types.EntityFragment
+	cbClient := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, f types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			fragment = f
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	_, err := CreateOrUpdateAirQualityObserved(context.Background(), cbClient, domain.DefaultCatalogue(), domain.ReadingScaled, data, "abc", 888100)
+	is.NoErr(err)
+
+	body, err := json.Marshal(fragment)
+	is.NoErr(err)
+
+	is.True(strings.Contains(string(body), `"NO2Quality":{"type":"Property","value":["Calibration"],"observedAt":"`+data[0].Timestamp.Timestamp+`"}`))
+}
+
+func TestThatBothReadingsPublishesThePrescaledReadingAsRaw(t *testing.T) {
+	is := is.New(t)
+
+	data := testData(is)
+	data[0].Channels[0].PreScaled.Reading = 3.5
+	data[0].Channels[0].Scaled.Reading = 3.9
+
+	var fragment types.EntityFragment
+	cbClient := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, f types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			fragment = f
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	_, err := CreateOrUpdateAirQualityObserved(context.Background(), cbClient, domain.DefaultCatalogue(), domain.ReadingBoth, data, "abc", 888100)
+	is.NoErr(err)
+
+	body, err := json.Marshal(fragment)
+	is.NoErr(err)
+
+	is.True(strings.Contains(string(body), `"NO2":{"type":"Property","value":3.9,`))
+	is.True(strings.Contains(string(body), `"raw":{"type":"Property","value":3.5}`))
+}
+
+func TestThatCalibrationVersionIsPublishedWithTheReading(t *testing.T) {
+	is := is.New(t)
+
+	data := testData(is)
+	data[0].Channels[0].Scaled.Reading = 4.2
+	data[0].Channels[0].Calibration = "no2-v1"
+
+	var fragments []string
+	cbClient := &test.ContextBrokerClientMock{
+		MergeEntityFunc: func(ctx context.Context, entityID string, f types.EntityFragment, headers map[string][]string) (*ngsild.MergeEntityResult, error) {
+			body, _ := json.Marshal(f)
+			fragments = append(fragments, string(body))
+			return &ngsild.MergeEntityResult{}, nil
+		},
+	}
+
+	for _, reading := range []domain.ReadingSelection{domain.ReadingScaled, domain.ReadingPreScaled} {
+		_, err := CreateOrUpdateAirQualityObserved(context.Background(), cbClient, domain.DefaultCatalogue(), reading, data, "abc", 888100)
+		is.NoErr(err)
+	}
+
+	is.True(strings.Contains(fragments[0], `"calibration":{"type":"Property","value":"no2-v1"}`))
+	is.True(strings.Contains(fragments[1], `"calibration":{"type":"Property","value":"no2-v1"}`))
+}
+
+func testData(is *is.I) []domain.DeviceData {
+	var data []domain.DeviceData
+	is.NoErr(json.Unmarshal([]byte(deviceDataJson), &data))
+	return data
+}
+
+const deviceDataJson string = `
+[
+  {
+    "Channels": [
+      {
+        "Channel": 11,
+        "Offset": 0,
+        "PreScaled": {
+          "Reading": 3.888
+        },
+        "Scaled": {
+          "Reading": 3.888
+        },
+        "SensorLabel": "NO2",
+        "SensorName": "Nitrogen Dioxide",
+        "Slope": 1,
+        "UnitName": "Parts Per Billion"
+      }
+    ],
+    "Location": {
+      "Altitude": null,
+      "Latitude": 62.388618,
+      "Longitude": 17.308968
+    },
+    "Timestamp": {
+      "Convention": "TimeBeginning",
+      "Timestamp": "2023-08-27T22:08:00+00:00"
+    }
+  }
+]
+`
@@ -0,0 +1,24 @@
+package fiware
+
+import (
+	"context"
+
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var entityCounter = application.LazyCounter("integration-acoem/fiware",
+	"fiware.entities", "Entities merged or created in the context broker, by operation and result", "{entity}")
+
+func countEntity(ctx context.Context, operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	entityCounter().Add(ctx, 1, metric.WithAttributes(
+		attribute.String("operation", operation),
+		attribute.String("result", result),
+	))
+}
@@ -9,11 +9,13 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/diwise/integration-acoem/domain"
+	"github.com/diwise/integration-acoem/internal/pkg/application"
 	"github.com/diwise/senml"
 	"github.com/diwise/service-chassis/pkg/infrastructure/env"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
@@ -30,13 +32,10 @@ func init() {
 
 var tracer = otel.Tracer("integration-acoem/lwm2m")
 
-const (
-	AirQualityURN  string = "urn:oma:lwm2m:ext:3428"
-	HumidityURN    string = "urn:oma:lwm2m:ext:3304"
-	TemperatureURN string = "urn:oma:lwm2m:ext:3303"
-)
-
-func CreateAndSendAsLWM2M(ctx context.Context, sensors []domain.DeviceData, uniqueId int, url string, sender SenderFunc) error {
+// CreateAndSendAsLWM2M sends the channels of the device data as the lwm2m object resources
+// given by the catalogue, converted to the unit of each resource, with one pack per object
+// and timestamp
+func CreateAndSendAsLWM2M(ctx context.Context, catalogue *domain.Catalogue, reading domain.ReadingSelection, sensors []domain.DeviceData, uniqueId int, url string, sender SenderFunc) error {
 	logger := logging.GetFromContext(ctx)
 
 	var errs []error
@@ -53,57 +52,46 @@ func CreateAndSendAsLWM2M(ctx context.Context, sensors []domain.DeviceData, uniq
 		}
 
 		packs := make(map[string]senml.Pack)
+		order := []string{} // packs are sent in the order their objects were first seen
+		dropped := []string{}
+
+		conditions := catalogue.ConditionsOf(s.Channels)
 
 		for _, c := range s.Channels {
-			if strings.EqualFold("Temperature", c.SensorName) {
-				if _, ok := packs[TemperatureURN]; !ok {
-					packs[TemperatureURN] = newPack(TemperatureURN, "5700", uniqueIdStr, c.PreScaled.Reading, senml.UnitCelsius, timestamp, timestamp)
-				}
-			}
-			if strings.EqualFold("Humidity", c.SensorName) {
-				if _, ok := packs[HumidityURN]; !ok {
-					packs[HumidityURN] = newPack(HumidityURN, "5700", uniqueIdStr, c.PreScaled.Reading, senml.UnitRelativeHumidity, timestamp, timestamp)
-				}
-			}
-			if strings.EqualFold("Particulate Matter (PM 10)", c.SensorName) {
-				if _, ok := packs[AirQualityURN]; !ok {
-					packs[AirQualityURN] = newPack(AirQualityURN, "1", uniqueIdStr, c.PreScaled.Reading, "ug/m3", timestamp, timestamp)
-				} else {
-					packs[AirQualityURN] = append(packs[AirQualityURN], newRec("1", c.PreScaled.Reading, "ug/m3", timestamp))
-				}
+			m, ok := catalogue.Lookup(c)
+			if !ok || m.Lwm2m == nil {
+				dropped = append(dropped, c.SensorName)
+				continue
 			}
-			if strings.EqualFold("Particulate Matter (PM 2.5)", c.SensorName) {
-				if _, ok := packs[AirQualityURN]; !ok {
-					packs[AirQualityURN] = newPack(AirQualityURN, "3", uniqueIdStr, c.PreScaled.Reading, "ug/m3", timestamp, timestamp)
-				} else {
-					packs[AirQualityURN] = append(packs[AirQualityURN], newRec("3", c.PreScaled.Reading, "ug/m3", timestamp))
-				}
-			}
-			if strings.EqualFold("Particulate Matter (PM 1)", c.SensorName) {
-				if _, ok := packs[AirQualityURN]; !ok {
-					packs[AirQualityURN] = newPack(AirQualityURN, "5", uniqueIdStr, c.PreScaled.Reading, "ug/m3", timestamp, timestamp)
-				} else {
-					packs[AirQualityURN] = append(packs[AirQualityURN], newRec("5", c.PreScaled.Reading, "ug/m3", timestamp))
-				}
+
+			res := m.Lwm2m
+
+			value, err := catalogue.Convert(c, m, reading.Value(c), res.Unit, conditions)
+			if err != nil {
+				log.Warn("could not convert reading", "sensor_name", c.SensorName, "err", err.Error())
+				dropped = append(dropped, c.SensorName)
+				continue
 			}
-			if strings.EqualFold("Nitrogen Dioxide", c.SensorName) {
-				if _, ok := packs[AirQualityURN]; !ok {
-					packs[AirQualityURN] = newPack(AirQualityURN, "15", uniqueIdStr, c.PreScaled.Reading, "ppm", timestamp, timestamp)
-				} else {
-					packs[AirQualityURN] = append(packs[AirQualityURN], newRec("15", c.PreScaled.Reading, "ppm", timestamp))
-				}
+
+			pack, ok := packs[res.ObjectURN]
+
+			if !ok {
+				order = append(order, res.ObjectURN)
+				packs[res.ObjectURN] = newPack(res.ObjectURN, res.ResourceID, uniqueIdStr, value, res.Unit, timestamp, timestamp)
+				continue
 			}
-			if strings.EqualFold("Nitric Oxide", c.SensorName) {
-				if _, ok := packs[AirQualityURN]; !ok {
-					packs[AirQualityURN] = newPack(AirQualityURN, "19", uniqueIdStr, c.PreScaled.Reading, "ppm", timestamp, timestamp)
-				} else {
-					packs[AirQualityURN] = append(packs[AirQualityURN], newRec("19", c.PreScaled.Reading, "ppm", timestamp))
-				}
+
+			// only the first value of each resource is sent
+			if !slices.ContainsFunc(pack, func(r senml.Record) bool { return r.Name == res.ResourceID }) {
+				packs[res.ObjectURN] = append(pack, newRec(res.ResourceID, value, res.Unit, timestamp))
 			}
 		}
 
-		for _, p := range packs {
-			err := sender(ctx, url, p)
+		application.CountChannels(ctx, "lwm2m", len(s.Channels), dropped)
+
+		for _, urn := range order {
+			err := sender(ctx, url, packs[urn])
+			countPack(ctx, err)
 			if err != nil {
 				log.Error("could not send pack", "err", err.Error())
 				errs = append(errs, err)
@@ -138,26 +126,64 @@ func newRec(name string, v float64, u string, t time.Time) senml.Record {
 
 type SenderFunc = func(context.Context, string, senml.Pack) error
 
-func Send(ctx context.Context, url string, pack senml.Pack) error {
-	var err error
+type sink struct {
+	url        string
+	catalogue  *domain.Catalogue
+	reading    domain.ReadingSelection
+	httpClient *http.Client
+}
 
-	ctx, span := tracer.Start(ctx, "send-object")
-	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+// NewSink returns a sink that sends the data of each device to url, as the lwm2m objects
+// given by the catalogue. LwM2M resources hold a single value, so selecting both readings
+// sends the scaled reading.
+func NewSink(url string, catalogue *domain.Catalogue, reading domain.ReadingSelection) application.Sink {
+	return &sink{
+		url:        url,
+		catalogue:  catalogue,
+		reading:    reading,
+		httpClient: newHTTPClient(),
+	}
+}
 
-	var httpClient http.Client
+func (s *sink) Name() string {
+	return "lwm2m"
+}
+
+func (s *sink) Deliver(ctx context.Context, device domain.Device, data []domain.DeviceData) error {
+	return CreateAndSendAsLWM2M(ctx, s.catalogue, s.reading, data, device.UniqueId, s.url, func(ctx context.Context, url string, pack senml.Pack) error {
+		return send(ctx, s.httpClient, url, pack)
+	})
+}
+
+func (s *sink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
 
+func Send(ctx context.Context, url string, pack senml.Pack) error {
+	return send(ctx, newHTTPClient(), url, pack)
+}
+
+func newHTTPClient() *http.Client {
 	if tlsSkipVerify {
 		customTransport := http.DefaultTransport.(*http.Transport).Clone()
 		customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		httpClient = http.Client{
+		return &http.Client{
 			Transport: otelhttp.NewTransport(customTransport),
 		}
-	} else {
-		httpClient = http.Client{
-			Transport: otelhttp.NewTransport(http.DefaultTransport),
-		}
 	}
 
+	return &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+}
+
+func send(ctx context.Context, httpClient *http.Client, url string, pack senml.Pack) error {
+	var err error
+
+	ctx, span := tracer.Start(ctx, "send-object")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
 	b, err := json.Marshal(pack)
 	if err != nil {
 		return err
@@ -173,7 +199,10 @@ func Send(ctx context.Context, url string, pack senml.Pack) error {
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return err
-	} else if resp.StatusCode != http.StatusCreated {
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
 		err = fmt.Errorf("unexpected response code %d", resp.StatusCode)
 	}
 
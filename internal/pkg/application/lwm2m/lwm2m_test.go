@@ -3,10 +3,16 @@ package lwm2m
 import (
 	"context"
 	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/diwise/integration-acoem/domain"
 	"github.com/diwise/senml"
+	testutils "github.com/diwise/service-chassis/pkg/test/http"
+	"github.com/diwise/service-chassis/pkg/test/http/expects"
+	"github.com/diwise/service-chassis/pkg/test/http/response"
 	"github.com/matryer/is"
 )
 
@@ -18,7 +24,7 @@ func TestCreateAndSendAsLWM2M(t *testing.T) {
 
 	packs := make([]senml.Pack, 0)
 
-	err := CreateAndSendAsLWM2M(context.Background(), deviceData, 11111, "/url", func(ctx context.Context, s string, p senml.Pack) error {
+	err := CreateAndSendAsLWM2M(context.Background(), domain.DefaultCatalogue(), domain.ReadingPreScaled, deviceData, 11111, "/url", func(ctx context.Context, s string, p senml.Pack) error {
 		packs = append(packs, p)
 		return nil
 	})
@@ -34,6 +40,104 @@ func TestCreateAndSendAsLWM2M(t *testing.T) {
 	is.Equal("11111/3304/0", rec.Name)
 }
 
+func TestChannelsAreSentAsResources(t *testing.T) {
+	tests := map[string]struct {
+		channel  string
+		urn      string
+		resource string
+		value    float64
+		unit     string
+	}{
+		"ozone":             {`{"sensorName": "Ozone", "sensorLabel": "O3", "preScaled": {"reading": 31.5}, "unitName": "Parts Per Billion"}`, "urn:oma:lwm2m:ext:3428", "9", 0.0315, "ppm"},
+		"sulphur dioxide":   {`{"sensorName": "Sulphur Dioxide", "sensorLabel": "SO2", "preScaled": {"reading": 2.1}, "unitName": "Parts Per Billion"}`, "urn:oma:lwm2m:ext:3428", "17", 0.0021, "ppm"},
+		"carbon monoxide":   {`{"sensorName": "Carbon Monoxide", "sensorLabel": "CO", "preScaled": {"reading": 210}, "unitName": "Parts Per Billion"}`, "urn:oma:lwm2m:ext:3428", "7", 0.21, "ppm"},
+		"carbon dioxide":    {`{"sensorName": "Carbon Dioxide", "sensorLabel": "CO2", "preScaled": {"reading": 415}, "unitName": "Parts Per Million"}`, "urn:oma:lwm2m:ext:3428", "23", 415, "ppm"},
+		"hydrogen sulphide": {`{"sensorName": "Hydrogen Sulphide", "sensorLabel": "H2S", "preScaled": {"reading": 0.4}, "unitName": "Parts Per Billion"}`, "urn:oma:lwm2m:ext:3428", "21", 0.0004, "ppm"},
+		"nitrogen oxides":   {`{"sensorName": "Nitrogen Oxides", "sensorLabel": "NOx", "preScaled": {"reading": 18}, "unitName": "Parts Per Billion"}`, "urn:oma:lwm2m:ext:3428", "13", 0.018, "ppm"},
+		"nitric oxide":      {`{"sensorName": "Nitric Oxide", "sensorLabel": "NO", "preScaled": {"reading": 12}, "unitName": "Parts Per Billion"}`, "urn:oma:lwm2m:ext:3428", "19", 0.012, "ppm"},
+		"nitrogen dioxide":  {`{"sensorName": "Nitrogen Dioxide", "sensorLabel": "NO2", "preScaled": {"reading": 21}, "unitName": "Parts Per Billion"}`, "urn:oma:lwm2m:ext:3428", "15", 0.021, "ppm"},
+		"pm4":               {`{"sensorName": "PM 4", "preScaled": {"reading": 7.5}, "unitName": "Micrograms Per Cubic Meter"}`, "urn:oma:lwm2m:ext:3428", "25", 7.5, "ug/m3"},
+		"tsp":               {`{"sensorName": "Total Suspended Particulate", "preScaled": {"reading": 22}, "unitName": "Micrograms Per Cubic Meter"}`, "urn:oma:lwm2m:ext:3428", "27", 22, "ug/m3"},
+		"pressure":          {`{"sensorName": "Air Pressure", "preScaled": {"reading": 1013.2}, "unitName": "Hectopascals"}`, "urn:oma:lwm2m:ext:3315", "5700", 1013.2, "hPa"},
+		"voltage":           {`{"sensorName": "Voltage", "preScaled": {"reading": 12.4}, "unitName": "Volts"}`, "urn:oma:lwm2m:ext:3316", "5700", 12.4, "V"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+
+			packs := sendAndCollect(is, tc.channel)
+			is.Equal(1, len(packs))
+
+			urn, ok := packs[0].GetStringValue(senml.FindByName("0"))
+			is.True(ok)
+			is.Equal(tc.urn, urn)
+
+			v, u, ok := packs[0].GetValueWithUnit(senml.FindByName(tc.resource))
+			is.True(ok)
+			is.True(math.Abs(tc.value-v) < 1e-9) // the value of the resource
+			is.Equal(tc.unit, u)
+		})
+	}
+}
+
+func TestChannelsOfTheSameObjectAreSentTogether(t *testing.T) {
+	is := is.New(t)
+
+	packs := sendAndCollect(is,
+		`{"sensorName": "Air Pressure", "preScaled": {"reading": 1013.2}, "unitName": "Hectopascals"}`,
+		`{"sensorName": "Nitrogen Oxides", "sensorLabel": "NOx", "preScaled": {"reading": 18}, "unitName": "Parts Per Billion"}`,
+		`{"sensorName": "PM 4", "preScaled": {"reading": 7.5}, "unitName": "Micrograms Per Cubic Meter"}`,
+		`{"sensorName": "Total Suspended Particulate", "preScaled": {"reading": 22}, "unitName": "Micrograms Per Cubic Meter"}`,
+	)
+
+	is.Equal(2, len(packs))
+	is.Equal(4, len(packs[1])) // the object record followed by NOx, PM4 and TSP
+}
+
+func TestSinkPostsEachObjectToEndpoint(t *testing.T) {
+	is := is.New(t)
+
+	s := testutils.NewMockServiceThat(
+		testutils.Expects(
+			is,
+			expects.RequestMethod(http.MethodPost),
+			expects.RequestPath("/api/v0/messages/lwm2m"),
+		),
+		testutils.Returns(
+			response.Code(http.StatusCreated),
+		),
+	)
+
+	var deviceData []domain.DeviceData
+	is.NoErr(json.Unmarshal([]byte(devicedataJson), &deviceData))
+
+	sink := NewSink(s.URL()+"/api/v0/messages/lwm2m", domain.DefaultCatalogue(), domain.ReadingScaled)
+	defer sink.Close()
+
+	err := sink.Deliver(context.Background(), domain.Device{UniqueId: 11111}, deviceData)
+	is.NoErr(err)
+	is.Equal(3, s.RequestCount())
+}
+
+// sendAndCollect sends a record with the given channels, in JSON, and returns the packs that were sent
+func sendAndCollect(is *is.I, channels ...string) []senml.Pack {
+	data := `[{"timestamp": {"timestamp": "2024-03-01T10:00:00+00:00"}, "channels": [` + strings.Join(channels, ",") + `]}]`
+
+	var deviceData []domain.DeviceData
+	is.NoErr(json.Unmarshal([]byte(data), &deviceData))
+
+	packs := make([]senml.Pack, 0)
+
+	err := CreateAndSendAsLWM2M(context.Background(), domain.DefaultCatalogue(), domain.ReadingPreScaled, deviceData, 11111, "/url", func(ctx context.Context, s string, p senml.Pack) error {
+		packs = append(packs, p)
+		return nil
+	})
+	is.NoErr(err)
+
+	return packs
+}
+
 const devicedataJson string = `
 [
   {
@@ -0,0 +1,21 @@
+package lwm2m
+
+import (
+	"context"
+
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var packCounter = application.LazyCounter("integration-acoem/lwm2m",
+	"lwm2m.packs", "SenML packs sent to the lwm2m endpoint, by result", "{pack}")
+
+func countPack(ctx context.Context, err error) {
+	result := "sent"
+	if err != nil {
+		result = "failed"
+	}
+
+	packCounter().Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
@@ -0,0 +1,70 @@
+package application
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Instruments are created by constructors, or on first use by packages without one, rather
+// than when a package is initialised, so that they are bound to the meter provider installed
+// during startup. A failure to create an instrument leaves a no-op instrument, so such errors
+// are ignored.
+
+const meterName string = "integration-acoem/app"
+
+// meter returns the meter used by the Acoem client
+func meter() metric.Meter {
+	return otel.Meter(meterName)
+}
+
+// NewCounter creates a counter using the meter with the given name, such as "integration-acoem/fiware"
+func NewCounter(meterName, name, description, unit string) metric.Int64Counter {
+	counter, _ := otel.Meter(meterName).Int64Counter(name, metric.WithDescription(description), metric.WithUnit(unit))
+	return counter
+}
+
+// LazyCounter returns a function that creates the counter on first use and then keeps
+// returning it, for packages that have no constructor to create the counter in
+func LazyCounter(meterName, name, description, unit string) func() metric.Int64Counter {
+	return sync.OnceValue(func() metric.Int64Counter {
+		return NewCounter(meterName, name, description, unit)
+	})
+}
+
+func newRequestCounter() metric.Int64Counter {
+	return NewCounter(meterName, "acoem.requests", "Requests sent to Acoem, by endpoint and response status", "{request}")
+}
+
+type channelCounters struct {
+	received metric.Int64Counter
+	mapped   metric.Int64Counter
+	dropped  metric.Int64Counter
+}
+
+// channels are counted by the outputs, which are plain functions, so the counters are
+// created on first use
+var channels = sync.OnceValue(func() channelCounters {
+	return channelCounters{
+		received: NewCounter(meterName, "acoem.channels.received", "Channels received from Acoem by an output", "{channel}"),
+		mapped:   NewCounter(meterName, "acoem.channels.mapped", "Channels that an output could map to its data model", "{channel}"),
+		dropped:  NewCounter(meterName, "acoem.channels.dropped", "Channels dropped by an output due to an unknown sensor name or a failed unit conversion", "{channel}"),
+	}
+})
+
+// CountChannels records that an output received a number of channels, of which the channels
+// of the sensors in dropped could not be mapped or converted and were dropped
+func CountChannels(ctx context.Context, output string, received int, dropped []string) {
+	c := channels()
+	outputAttr := attribute.String("output", output)
+
+	c.received.Add(ctx, int64(received), metric.WithAttributes(outputAttr))
+	c.mapped.Add(ctx, int64(received-len(dropped)), metric.WithAttributes(outputAttr))
+
+	for _, sensorName := range dropped {
+		c.dropped.Add(ctx, 1, metric.WithAttributes(outputAttr, attribute.String("sensor_name", sensorName)))
+	}
+}
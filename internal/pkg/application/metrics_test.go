@@ -0,0 +1,57 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	testutils "github.com/diwise/service-chassis/pkg/test/http"
+	"github.com/diwise/service-chassis/pkg/test/http/response"
+	"github.com/matryer/is"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestThatRequestsAreCountedByEndpointAndStatus(t *testing.T) {
+	is := is.New(t)
+
+	reader := sdkmetric.NewManualReader()
+	previous := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	s := testutils.NewMockServiceThat(
+		Expects(is, method(http.MethodGet)),
+		Returns(
+			response.Code(http.StatusNotFound),
+			response.Body([]byte("")),
+		),
+	)
+
+	mockApp := newMockApp(t, s.URL())
+
+	_, err := mockApp.GetDevices(context.Background())
+	is.True(err != nil)
+
+	rm := metricdata.ResourceMetrics{}
+	is.NoErr(reader.Collect(context.Background(), &rm))
+
+	var points []metricdata.DataPoint[int64]
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == "acoem.requests" {
+				points = m.Data.(metricdata.Sum[int64]).DataPoints
+			}
+		}
+	}
+
+	is.Equal(1, len(points))
+	is.Equal(int64(1), points[0].Value)
+
+	endpoint, _ := points[0].Attributes.Value(attribute.Key("endpoint"))
+	status, _ := points[0].Attributes.Value(attribute.Key("status"))
+	is.Equal(EndpointDevices, endpoint.AsString())
+	is.Equal("404", status.AsString())
+}
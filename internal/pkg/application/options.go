@@ -0,0 +1,95 @@
+package application
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DataTypeData           string = "data"
+	DataTypeDiagnostic     string = "diagnostic"
+	DataTypeDataDiagnostic string = "datadiagnostic"
+)
+
+// validAveragingSeconds are the averaging periods, in seconds, that Acoem can calculate
+var validAveragingSeconds = []int{0, 300, 600, 900, 1200, 1800, 3600, 7200, 10800, 14400, 21600, 28800, 43200, 86400}
+
+type Option func(*integrationAcoem) error
+
+// WithAveragingPeriod sets the averaging period of the retrieved data using Acoem's
+// notation, i.e. 'AVG' or 'AVERAGE' followed by the period in seconds, such as AVG300.
+func WithAveragingPeriod(average string) Option {
+	return func(i *integrationAcoem) error {
+		seconds, err := parseAveragingSeconds(average)
+		if err != nil {
+			return err
+		}
+
+		i.average = fmt.Sprintf("AVG%d", seconds)
+		return nil
+	}
+}
+
+// WithNumberOfRecords sets the number of records retrieved from the latest data of a device
+func WithNumberOfRecords(numberOfRecords int) Option {
+	return func(i *integrationAcoem) error {
+		if numberOfRecords < 1 {
+			return fmt.Errorf("number of records must be at least 1, got %d", numberOfRecords)
+		}
+
+		i.numberOfRecords = numberOfRecords
+		return nil
+	}
+}
+
+// WithDataType sets the type of data to retrieve, which can be 'data', 'diagnostic' or 'datadiagnostic'
+func WithDataType(dataType string) Option {
+	return func(i *integrationAcoem) error {
+		dataType = strings.ToLower(dataType)
+
+		if dataType != DataTypeData && dataType != DataTypeDiagnostic && dataType != DataTypeDataDiagnostic {
+			return fmt.Errorf("invalid data type %q, expected one of %s, %s or %s", dataType, DataTypeData, DataTypeDiagnostic, DataTypeDataDiagnostic)
+		}
+
+		i.dataType = dataType
+		return nil
+	}
+}
+
+// ParseAveragingPeriod returns the duration of an averaging period in Acoem's notation,
+// such as AVG300. Raw data, AVG0, has a period of zero.
+func ParseAveragingPeriod(average string) (time.Duration, error) {
+	seconds, err := parseAveragingSeconds(average)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func parseAveragingSeconds(average string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(average))
+
+	switch {
+	case strings.HasPrefix(s, "AVERAGE"):
+		s = strings.TrimPrefix(s, "AVERAGE")
+	case strings.HasPrefix(s, "AVG"):
+		s = strings.TrimPrefix(s, "AVG")
+	default:
+		return 0, fmt.Errorf("invalid averaging period %q, expected AVG or AVERAGE followed by the period in seconds", average)
+	}
+
+	seconds, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid averaging period %q: %w", average, err)
+	}
+
+	if !slices.Contains(validAveragingSeconds, seconds) {
+		return 0, fmt.Errorf("invalid averaging period %q, valid periods in seconds are %v", average, validAveragingSeconds)
+	}
+
+	return seconds, nil
+}
@@ -0,0 +1,18 @@
+package outlier
+
+import (
+	"context"
+
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+func newOutlierCounter() metric.Int64Counter {
+	return application.NewCounter("integration-acoem/outlier",
+		"acoem.channels.outliers", "Channels with a reading detected as an outlier, by check and action", "{channel}")
+}
+
+func (p *processor) count(ctx context.Context, check string) {
+	p.outliers.Add(ctx, 1, metric.WithAttributes(attribute.String("check", check), attribute.String("action", string(p.config.Action))))
+}
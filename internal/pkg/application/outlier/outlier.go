@@ -0,0 +1,210 @@
+package outlier
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Action decides what happens to a channel with a reading that is detected as an outlier
+type Action string
+
+const (
+	ActionDrop     Action = "drop"     // the channel is not published
+	ActionAnnotate Action = "annotate" // the channel is published with the check as an annotation
+	ActionFlag     Action = "flag"     // the channel is published as is, and the outlier is only logged and counted
+)
+
+// ParseAction returns the action with the given name, ignoring case
+func ParseAction(name string) (Action, error) {
+	action := Action(strings.ToLower(name))
+
+	if action != ActionDrop && action != ActionAnnotate && action != ActionFlag {
+		return "", fmt.Errorf("invalid outlier action %q, expected one of %s, %s or %s", name, ActionDrop, ActionAnnotate, ActionFlag)
+	}
+
+	return action, nil
+}
+
+// The checks that detect outliers, which are also used as annotations
+const (
+	CheckOutOfRange string = "outOfRange" // the reading is outside of the range of the measurement
+	CheckSpike      string = "spike"      // the reading changed more than the max change of the measurement
+	CheckStuck      string = "stuck"      // the reading repeats the same value as the previous readings
+)
+
+// spikeLimit is the number of consecutive spikes after which a change is accepted as a new level
+const spikeLimit int = 3
+
+// Config configures the detection of outliers
+type Config struct {
+	Action Action
+	// StuckReadings is the number of identical consecutive readings from which a sensor is
+	// considered stuck, or zero to disable the check
+	StuckReadings int
+}
+
+// history is what is remembered about the previous readings of a sensor on a device
+type history struct {
+	observedAt time.Time
+	previous   float64  // the latest reading that was not an outlier
+	spikes     int      // the number of consecutive spikes since previous
+	last       float64  // the latest reading
+	repeats    int      // the number of consecutive readings equal to last
+	checks     []string // the checks that the latest reading failed
+}
+
+type processor struct {
+	catalogue *domain.Catalogue
+	reading   domain.ReadingSelection
+	config    Config
+
+	mu      sync.Mutex
+	history map[string]*history
+
+	outliers metric.Int64Counter
+}
+
+// NewProcessor returns a stage that detects published readings that are out of the range of
+// their measurement, that change more than the max change of the measurement since the
+// previous reading, or that repeat the same value too many times, and applies the configured
+// action to them. Ranges and max changes are given by the catalogue, in the unit of each measurement.
+func NewProcessor(catalogue *domain.Catalogue, reading domain.ReadingSelection, config Config) application.Processor {
+	return &processor{
+		catalogue: catalogue,
+		reading:   reading,
+		config:    config,
+		history:   map[string]*history{},
+		outliers:  newOutlierCounter(),
+	}
+}
+
+func (p *processor) Process(ctx context.Context, device domain.Device, data []domain.DeviceData) []domain.DeviceData {
+	logger := logging.GetFromContext(ctx)
+
+	// the history is advanced in the order the data was observed, regardless of the order it was retrieved in
+	order := make([]int, len(data))
+	observedAt := make([]time.Time, len(data))
+	for idx, d := range data {
+		order[idx] = idx
+		observedAt[idx], _ = time.Parse(time.RFC3339, d.Timestamp.Timestamp)
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return observedAt[a].Compare(observedAt[b]) })
+
+	result := slices.Clone(data)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, idx := range order {
+		d := result[idx]
+		conditions := p.catalogue.ConditionsOf(d.Channels)
+
+		channels := make([]domain.Channel, 0, len(d.Channels))
+
+		for _, ch := range d.Channels {
+			checks := p.check(device, ch, observedAt[idx], conditions)
+
+			if len(checks) > 0 {
+				logger.Warn("outlier detected", "device_id", device.UniqueId, "sensor_name", ch.SensorName, "timestamp", d.Timestamp.Timestamp, "checks", checks, "action", p.config.Action)
+
+				for _, check := range checks {
+					p.count(ctx, check)
+				}
+
+				switch p.config.Action {
+				case ActionDrop:
+					continue
+				case ActionAnnotate:
+					ch.Annotations = append(slices.Clone(ch.Annotations), checks...)
+				}
+			}
+
+			channels = append(channels, ch)
+		}
+
+		d.Channels = channels
+		result[idx] = d
+	}
+
+	return result
+}
+
+// check returns the checks that the published reading of a channel fails, and advances the
+// history of the sensor unless the data is older than what has already been seen
+func (p *processor) check(device domain.Device, ch domain.Channel, observedAt time.Time, conditions domain.Conditions) []string {
+	m, ok := p.catalogue.Lookup(ch)
+	if !ok {
+		return nil
+	}
+
+	value, err := p.catalogue.Convert(ch, m, p.reading.Value(ch), m.Unit, conditions)
+	if err != nil {
+		return nil
+	}
+
+	checks := []string{}
+
+	outOfRange := m.Range != nil && !m.Range.Contains(value)
+	if outOfRange {
+		checks = append(checks, CheckOutOfRange)
+	}
+
+	key := fmt.Sprintf("%d/%s", device.UniqueId, strings.ToLower(m.SensorName))
+	h, ok := p.history[key]
+
+	if !ok {
+		if !outOfRange {
+			p.history[key] = &history{observedAt: observedAt, previous: value, last: value, repeats: 1}
+		}
+		return checks
+	}
+
+	// data that has already been seen, such as when it is retrieved again after a failed
+	// delivery, gets the same result as before if it is the latest, and is otherwise only
+	// checked against the range
+	if observedAt.Equal(h.observedAt) {
+		return h.checks
+	}
+	if observedAt.Before(h.observedAt) {
+		return checks
+	}
+
+	h.observedAt = observedAt
+	defer func() { h.checks = checks }()
+
+	if value == h.last {
+		h.repeats++
+	} else {
+		h.last, h.repeats = value, 1
+	}
+
+	if p.config.StuckReadings > 0 && h.repeats >= p.config.StuckReadings {
+		checks = append(checks, CheckStuck)
+	}
+
+	if outOfRange {
+		return checks
+	}
+
+	if m.MaxChange > 0 && math.Abs(value-h.previous) > m.MaxChange {
+		h.spikes++
+		if h.spikes < spikeLimit {
+			checks = append(checks, CheckSpike)
+			return checks
+		}
+	}
+
+	h.previous, h.spikes = value, 0
+
+	return checks
+}
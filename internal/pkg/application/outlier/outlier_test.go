@@ -0,0 +1,150 @@
+package outlier
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/matryer/is"
+)
+
+func TestReadingsOutOfRangeAreDropped(t *testing.T) {
+	is := is.New(t)
+
+	p := NewProcessor(domain.DefaultCatalogue(), domain.ReadingScaled, Config{Action: ActionDrop})
+
+	data := []domain.DeviceData{{
+		Timestamp: domain.Timestamp{Timestamp: "2024-05-01T10:00:00+00:00"},
+		Channels: []domain.Channel{
+			{SensorName: "Temperature", Scaled: domain.Reading{Reading: 85}},
+			{SensorName: "Humidity", Scaled: domain.Reading{Reading: 45}},
+			{SensorName: "Unknown", Scaled: domain.Reading{Reading: 1e9}}, // not in the catalogue
+		},
+	}}
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, data)
+
+	is.Equal(2, len(result[0].Channels))
+	is.Equal("Humidity", result[0].Channels[0].SensorName)
+	is.Equal("Unknown", result[0].Channels[1].SensorName)
+	is.Equal(3, len(data[0].Channels)) // the input is left unchanged
+}
+
+func TestReadingsOutOfRangeAreAnnotated(t *testing.T) {
+	is := is.New(t)
+
+	p := NewProcessor(domain.DefaultCatalogue(), domain.ReadingScaled, Config{Action: ActionAnnotate})
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{{
+		Timestamp: domain.Timestamp{Timestamp: "2024-05-01T10:00:00+00:00"},
+		Channels: []domain.Channel{
+			{SensorName: "Temperature", Scaled: domain.Reading{Reading: -70}},
+			{SensorName: "Humidity", Scaled: domain.Reading{Reading: 45}},
+		},
+	}})
+
+	is.Equal([]string{CheckOutOfRange}, result[0].Channels[0].Annotations)
+	is.Equal(0, len(result[0].Channels[1].Annotations))
+}
+
+func TestReadingsOutOfRangeAreOnlyFlagged(t *testing.T) {
+	is := is.New(t)
+
+	p := NewProcessor(domain.DefaultCatalogue(), domain.ReadingScaled, Config{Action: ActionFlag})
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{{
+		Timestamp: domain.Timestamp{Timestamp: "2024-05-01T10:00:00+00:00"},
+		Channels:  []domain.Channel{{SensorName: "Temperature", Scaled: domain.Reading{Reading: 85}}},
+	}})
+
+	is.Equal(1, len(result[0].Channels))
+	is.Equal(0, len(result[0].Channels[0].Annotations))
+}
+
+func TestThePublishedReadingIsChecked(t *testing.T) {
+	is := is.New(t)
+
+	p := NewProcessor(domain.DefaultCatalogue(), domain.ReadingPreScaled, Config{Action: ActionDrop})
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{{
+		Timestamp: domain.Timestamp{Timestamp: "2024-05-01T10:00:00+00:00"},
+		Channels: []domain.Channel{
+			{SensorName: "Temperature", PreScaled: domain.Reading{Reading: 9999}, Scaled: domain.Reading{Reading: 20}},
+		},
+	}})
+
+	is.Equal(0, len(result[0].Channels))
+}
+
+func TestSpikesAreDetectedUntilTheNewLevelPersists(t *testing.T) {
+	is := is.New(t)
+
+	c := domain.DefaultCatalogue()
+	for idx := range c.Measurements {
+		if c.Measurements[idx].SensorName == "Nitrogen Dioxide" {
+			c.Measurements[idx].MaxChange = 50
+		}
+	}
+
+	p := NewProcessor(c, domain.ReadingScaled, Config{Action: ActionAnnotate})
+
+	readings := []float64{10, 20, 200, 30, 300, 310, 305, 320}
+	expected := [][]string{nil, nil, {CheckSpike}, nil, {CheckSpike}, {CheckSpike}, nil, nil}
+
+	for idx, reading := range readings {
+		result := p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{{
+			Timestamp: domain.Timestamp{Timestamp: fmt.Sprintf("2024-05-01T10:%02d:00+00:00", idx*5)},
+			Channels:  []domain.Channel{{SensorName: "Nitrogen Dioxide", Scaled: domain.Reading{Reading: reading}}},
+		}})
+		is.Equal(expected[idx], result[0].Channels[0].Annotations) // reading idx
+	}
+}
+
+func TestStuckSensorsAreDetected(t *testing.T) {
+	is := is.New(t)
+
+	p := NewProcessor(domain.DefaultCatalogue(), domain.ReadingScaled, Config{Action: ActionDrop, StuckReadings: 3})
+
+	// the data is checked in the order it was observed, and data that has been seen before
+	// does not count as a repeated reading
+	data := []domain.DeviceData{}
+	for _, r := range []struct {
+		timestamp string
+		reading   float64
+	}{
+		{"2024-05-01T10:10:00+00:00", 12},
+		{"2024-05-01T10:05:00+00:00", 12},
+		{"2024-05-01T10:00:00+00:00", 11},
+		{"2024-05-01T10:15:00+00:00", 12},
+	} {
+		data = append(data, domain.DeviceData{
+			Timestamp: domain.Timestamp{Timestamp: r.timestamp},
+			Channels:  []domain.Channel{{SensorName: "Ozone", Scaled: domain.Reading{Reading: r.reading}}},
+		})
+	}
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, data)
+	is.Equal(1, len(result[0].Channels))
+	is.Equal(1, len(result[1].Channels))
+	is.Equal(1, len(result[2].Channels))
+	is.Equal(0, len(result[3].Channels))
+
+	result = p.Process(context.Background(), domain.Device{UniqueId: 1}, data[3:])
+	is.Equal(0, len(result[0].Channels))
+
+	// the history is kept per device
+	result = p.Process(context.Background(), domain.Device{UniqueId: 2}, data[3:])
+	is.Equal(1, len(result[0].Channels))
+}
+
+func TestParseAction(t *testing.T) {
+	is := is.New(t)
+
+	action, err := ParseAction("Annotate")
+	is.NoErr(err)
+	is.Equal(ActionAnnotate, action)
+
+	_, err = ParseAction("ignore")
+	is.True(err != nil)
+}
@@ -0,0 +1,15 @@
+package application
+
+import (
+	"context"
+
+	"github.com/diwise/integration-acoem/domain"
+)
+
+// Processor is a stage that device data passes through after it has been retrieved from
+// Acoem and before it is delivered to the sinks, such as a data quality check
+type Processor interface {
+	// Process returns the data to deliver, with channels removed or annotated as needed,
+	// and leaves the given data unchanged
+	Process(ctx context.Context, device domain.Device, data []domain.DeviceData) []domain.DeviceData
+}
@@ -0,0 +1,18 @@
+package quality
+
+import (
+	"context"
+
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+func newChannelCounter() metric.Int64Counter {
+	return application.NewCounter("integration-acoem/quality",
+		"acoem.channels.quality", "Channels rejected or annotated by the quality policy, by action and reason", "{channel}")
+}
+
+func (p *processor) count(ctx context.Context, action, reason string) {
+	p.channels.Add(ctx, 1, metric.WithAttributes(attribute.String("action", action), attribute.String("reason", reason)))
+}
@@ -0,0 +1,104 @@
+package quality
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Policy decides which channels are good enough to be published
+type Policy struct {
+	// MinValidPercentage rejects channels with a lower valid percentage in either of their readings
+	MinValidPercentage float64
+	// RejectFlags are the Acoem flags that cause a channel to be rejected, ignoring case
+	RejectFlags []string
+	// AnnotateFlags are the Acoem flags that are published as annotations of a channel, ignoring case
+	AnnotateFlags []string
+}
+
+type processor struct {
+	policy   Policy
+	channels metric.Int64Counter
+}
+
+// NewProcessor returns a stage that removes the channels rejected by the policy and
+// annotates the channels that carry any of the flags to annotate
+func NewProcessor(policy Policy) application.Processor {
+	return &processor{
+		policy:   policy,
+		channels: newChannelCounter(),
+	}
+}
+
+func (p *processor) Process(ctx context.Context, device domain.Device, data []domain.DeviceData) []domain.DeviceData {
+	logger := logging.GetFromContext(ctx)
+
+	result := make([]domain.DeviceData, 0, len(data))
+
+	for _, d := range data {
+		channels := make([]domain.Channel, 0, len(d.Channels))
+
+		for _, ch := range d.Channels {
+			flags := flagsOf(ch)
+
+			if reason, rejected := p.rejects(ch, flags); rejected {
+				logger.Debug("channel rejected by quality policy", "device_id", device.UniqueId, "sensor_name", ch.SensorName, "timestamp", d.Timestamp.Timestamp, "reason", reason)
+				p.count(ctx, "rejected", reason)
+				continue
+			}
+
+			for _, flag := range flags {
+				if containsFold(p.policy.AnnotateFlags, flag) && !slices.Contains(ch.Annotations, flag) {
+					ch.Annotations = append(slices.Clone(ch.Annotations), flag)
+					p.count(ctx, "annotated", flag)
+				}
+			}
+
+			channels = append(channels, ch)
+		}
+
+		d.Channels = channels
+		result = append(result, d)
+	}
+
+	return result
+}
+
+// rejects returns the reason a channel is rejected by the policy, if any
+func (p *processor) rejects(ch domain.Channel, flags []string) (string, bool) {
+	for _, r := range []domain.Reading{ch.PreScaled, ch.Scaled} {
+		if r.ValidPercentage != nil && *r.ValidPercentage < p.policy.MinValidPercentage {
+			return "valid_percentage", true
+		}
+	}
+
+	for _, flag := range flags {
+		if containsFold(p.policy.RejectFlags, flag) {
+			return flag, true
+		}
+	}
+
+	return "", false
+}
+
+// flagsOf returns the distinct flags of a channel and its readings
+func flagsOf(ch domain.Channel) []string {
+	flags := []string{}
+
+	for _, f := range slices.Concat(ch.Flags, ch.PreScaled.Flags, ch.Scaled.Flags) {
+		if !containsFold(flags, f) {
+			flags = append(flags, f)
+		}
+	}
+
+	return flags
+}
+
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, value) })
+}
@@ -0,0 +1,78 @@
+package quality
+
+import (
+	"context"
+	"testing"
+
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/matryer/is"
+)
+
+func TestChannelsBelowMinValidPercentageAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	p := NewProcessor(Policy{MinValidPercentage: 75})
+
+	data := []domain.DeviceData{newDeviceData(
+		newChannel("Nitrogen Dioxide", 100, nil),
+		newChannel("Ozone", 50, nil),
+		domain.Channel{SensorName: "Temperature"}, // no valid percentage reported
+	)}
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, data)
+
+	is.Equal(1, len(result))
+	is.Equal([]string{"Nitrogen Dioxide", "Temperature"}, sensorNames(result[0]))
+	is.Equal(3, len(data[0].Channels)) // the input is left unchanged
+}
+
+func TestChannelsWithRejectFlagsAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	p := NewProcessor(Policy{RejectFlags: []string{"Maintenance"}})
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, []domain.DeviceData{newDeviceData(
+		newChannel("Nitrogen Dioxide", 100, []string{"Calibration"}),
+		newChannel("Ozone", 100, []string{"maintenance"}),
+	)})
+
+	is.Equal([]string{"Nitrogen Dioxide"}, sensorNames(result[0]))
+}
+
+func TestChannelsWithAnnotateFlagsAreAnnotated(t *testing.T) {
+	is := is.New(t)
+
+	p := NewProcessor(Policy{AnnotateFlags: []string{"calibration"}})
+
+	ch := newChannel("Nitrogen Dioxide", 100, []string{"Calibration", "Other"})
+	ch.Flags = []string{"CALIBRATION"}
+
+	data := []domain.DeviceData{newDeviceData(ch, newChannel("Ozone", 100, nil))}
+
+	result := p.Process(context.Background(), domain.Device{UniqueId: 1}, data)
+
+	is.Equal([]string{"CALIBRATION"}, result[0].Channels[0].Annotations)
+	is.Equal(0, len(result[0].Channels[1].Annotations))
+	is.Equal(0, len(data[0].Channels[0].Annotations))
+}
+
+func newChannel(sensorName string, validPercentage float64, flags []string) domain.Channel {
+	ch := domain.Channel{SensorName: sensorName}
+	ch.Scaled.ValidPercentage = &validPercentage
+	ch.Scaled.Flags = flags
+	return ch
+}
+
+func newDeviceData(channels ...domain.Channel) domain.DeviceData {
+	d := domain.DeviceData{Channels: channels}
+	d.Timestamp.Timestamp = "2024-05-01T10:05:00+00:00"
+	return d
+}
+
+func sensorNames(d domain.DeviceData) []string {
+	names := []string{}
+	for _, ch := range d.Channels {
+		names = append(names, ch.SensorName)
+	}
+	return names
+}
@@ -0,0 +1,147 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithRateLimit limits the requests sent to Acoem to requestsPerSecond on average, while
+// allowing bursts of up to burst requests. The limit is shared by all endpoints and all
+// concurrent callers. A rate of zero disables the limit.
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(i *integrationAcoem) error {
+		if requestsPerSecond < 0 {
+			return fmt.Errorf("rate limit cannot be negative, got %f", requestsPerSecond)
+		}
+
+		if requestsPerSecond == 0 {
+			i.limiter = nil
+			return nil
+		}
+
+		if burst < 1 {
+			return fmt.Errorf("rate limit burst must be at least 1, got %d", burst)
+		}
+
+		i.limiter = newTokenBucket(requestsPerSecond, burst)
+		return nil
+	}
+}
+
+// tokenBucket is a token bucket rate limiter that is refilled continuously at rate
+// tokens per second, up to a maximum of burst tokens
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token from the bucket and returns how long the caller must wait
+// before the token may be used
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// release returns a reserved token that was never used
+func (b *tokenBucket) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+// Wait blocks until a token is available or ctx is done, and returns the time spent waiting
+func (b *tokenBucket) Wait(ctx context.Context) (time.Duration, error) {
+	delay := b.reserve(time.Now())
+	if delay == 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		b.release()
+		return 0, ctx.Err()
+	case <-timer.C:
+		return delay, nil
+	}
+}
+
+// rateLimitedTransport delays requests so that they stay within the limits of a token bucket
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *tokenBucket
+	waited  metric.Float64Histogram
+}
+
+func newRateLimitedTransport(next http.RoundTripper, limiter *tokenBucket) http.RoundTripper {
+	if limiter == nil {
+		return next
+	}
+
+	waited, _ := meter().Float64Histogram(
+		"acoem.ratelimit.wait",
+		metric.WithDescription("Time spent waiting for the client side rate limit before sending a request to Acoem"),
+		metric.WithUnit("s"),
+	)
+
+	return &rateLimitedTransport{
+		next:    next,
+		limiter: limiter,
+		waited:  waited,
+	}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	waited, err := t.limiter.Wait(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cancelled while waiting for rate limit: %w", err)
+	}
+
+	t.waited.Record(ctx, waited.Seconds())
+
+	if waited > 0 {
+		trace.SpanFromContext(ctx).AddEvent("rate limited", trace.WithAttributes(
+			attribute.String("http.url", req.URL.Path),
+			attribute.String("ratelimit.wait", waited.String()),
+		))
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,48 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatTokenBucketAllowsBurstAndThenLimitsRate(t *testing.T) {
+	is := is.New(t)
+
+	b := newTokenBucket(2, 2)
+	now := b.last
+
+	is.Equal(time.Duration(0), b.reserve(now))
+	is.Equal(time.Duration(0), b.reserve(now))
+	is.Equal(500*time.Millisecond, b.reserve(now))
+	is.Equal(1000*time.Millisecond, b.reserve(now))
+
+	// after two seconds the four reserved tokens have been refilled
+	is.Equal(time.Duration(0), b.reserve(now.Add(2*time.Second)))
+}
+
+func TestThatWaitForTokenIsCancelledWithContext(t *testing.T) {
+	is := is.New(t)
+
+	b := newTokenBucket(0.1, 1)
+	_, err := b.Wait(context.Background())
+	is.NoErr(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = b.Wait(ctx)
+	is.True(err != nil)
+}
+
+func TestThatNewFailsOnInvalidRateLimit(t *testing.T) {
+	is := is.New(t)
+
+	_, err := New("http://localhost", "user", "pass", WithRateLimit(-1, 1))
+	is.True(err != nil)
+
+	_, err = New("http://localhost", "user", "pass", WithRateLimit(1, 0))
+	is.True(err != nil)
+}
@@ -0,0 +1,157 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// WithRetries sets how many times a failed request to Acoem is retried. The delay
+// between attempts starts at baseDelay and doubles for each retry, up to maxDelay,
+// unless Acoem specifies a delay using a Retry-After header. Such a delay is always
+// waited in full, and the request is not retried if the delay would exceed the
+// deadline of the request.
+func WithRetries(maxRetries int, baseDelay, maxDelay time.Duration) Option {
+	return func(i *integrationAcoem) error {
+		if maxRetries < 0 {
+			return fmt.Errorf("number of retries cannot be negative, got %d", maxRetries)
+		}
+
+		if maxRetries > 0 && (baseDelay <= 0 || maxDelay < baseDelay) {
+			return fmt.Errorf("invalid retry delays, base delay %s must be positive and not exceed max delay %s", baseDelay, maxDelay)
+		}
+
+		i.retry = retryPolicy{
+			maxRetries: maxRetries,
+			baseDelay:  baseDelay,
+			maxDelay:   maxDelay,
+		}
+		return nil
+	}
+}
+
+// retryTransport retries requests that failed because of network errors or because
+// Acoem was temporarily unable to handle them
+type retryTransport struct {
+	next   http.RoundTripper
+	policy retryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	// only requests without a body can be safely sent again
+	if req.Body != nil && req.Body != http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req.Clone(ctx))
+
+		reason, retryable := shouldRetry(ctx, resp, err)
+		if !retryable || attempt > t.policy.maxRetries {
+			return resp, err
+		}
+
+		delay := t.policy.backoff(attempt)
+
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				// retrying before Acoem is ready would only use up more of the quota, so the
+				// response is returned as is if there is not enough time left to wait
+				if deadline, ok := ctx.Deadline(); ok && time.Now().Add(retryAfter).After(deadline) {
+					return resp, err
+				}
+				delay = retryAfter
+			}
+
+			// drain the body so that the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		trace.SpanFromContext(ctx).AddEvent("retrying request", trace.WithAttributes(
+			attribute.String("http.url", req.URL.Path),
+			attribute.Int("retry.attempt", attempt),
+			attribute.String("retry.reason", reason),
+			attribute.String("retry.delay", delay.String()),
+		))
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("gave up retrying after %d attempts (%s): %w", attempt, reason, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the given retry, doubling for each attempt with
+// added jitter so that concurrent workers do not retry in lockstep
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	// doubled one step at a time, since shifting could overflow for large attempts
+	delay := p.baseDelay
+	for range attempt - 1 {
+		if delay >= p.maxDelay {
+			break
+		}
+		delay *= 2
+	}
+	delay = min(delay, p.maxDelay)
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return "", false
+		}
+		return err.Error(), true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Sprintf("status code %d", resp.StatusCode), true
+	}
+
+	return "", false
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which may be either a
+// number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,150 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatRequestsAreRetriedOnServiceUnavailable(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[{"uniqueID":888100,"deviceName":"abc"}]`))
+	}))
+	defer s.Close()
+
+	app, err := New(s.URL, "user", "pass", WithRetries(3, time.Millisecond, 10*time.Millisecond))
+	is.NoErr(err)
+
+	devices, err := app.GetDevices(context.Background())
+	is.NoErr(err)
+	is.Equal(1, len(devices))
+	is.Equal(3, requests)
+}
+
+func TestThatRetriesGiveUpAfterMaxRetries(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	app, err := New(s.URL, "user", "pass", WithRetries(2, time.Millisecond, 10*time.Millisecond))
+	is.NoErr(err)
+
+	_, err = app.GetDevices(context.Background())
+	is.True(err != nil)
+	is.Equal(3, requests)
+}
+
+func TestThatRetryAfterIsWaitedInFull(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 2 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}))
+	defer s.Close()
+
+	app, err := New(s.URL, "user", "pass", WithRetries(3, time.Millisecond, 10*time.Millisecond))
+	is.NoErr(err)
+
+	start := time.Now()
+	_, err = app.GetDevices(context.Background())
+	is.NoErr(err)
+	is.Equal(2, requests)
+	is.True(time.Since(start) >= time.Second) // longer than the max delay
+}
+
+func TestThatRetryAfterBeyondTheDeadlineIsNotRetried(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	app, err := New(s.URL, "user", "pass", WithRetries(3, time.Millisecond, 10*time.Millisecond))
+	is.NoErr(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = app.GetDevices(ctx)
+	is.True(errors.Is(err, ErrRateLimited))
+	is.Equal(1, requests)
+}
+
+func TestThatBackoffIsLimitedForManyAttempts(t *testing.T) {
+	is := is.New(t)
+
+	p := retryPolicy{maxRetries: 40, baseDelay: 5 * time.Second, maxDelay: time.Minute}
+
+	for attempt := 1; attempt <= 40; attempt++ {
+		delay := p.backoff(attempt)
+		is.True(delay > 0)
+		is.True(delay <= time.Minute)
+	}
+}
+
+func TestThatClientErrorsAreNotRetried(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+
+	app, err := New(s.URL, "user", "pass", WithRetries(2, time.Millisecond, 10*time.Millisecond))
+	is.NoErr(err)
+
+	_, err = app.GetDevices(context.Background())
+	is.True(err != nil)
+	is.Equal(1, requests)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("120", now)
+	is.True(ok)
+	is.Equal(2*time.Minute, d)
+
+	d, ok = parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now)
+	is.True(ok)
+	is.Equal(30*time.Second, d)
+
+	_, ok = parseRetryAfter("soon", now)
+	is.True(!ok)
+}
@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName string = "integration-acoem/scheduler"
+
+func newDeviceCounter() metric.Int64Counter {
+	return application.NewCounter(meterName, "acoem.devices.polled", "Devices processed by the scheduler, by outcome", "{device}")
+}
+
+// registerDataAge reports the age of the latest delivered data per device and output, as tracked by status
+func registerDataAge(status *statusTracker) {
+	_, _ = otel.Meter(meterName).Float64ObservableGauge(
+		"acoem.data.age",
+		metric.WithDescription("Time since the latest delivered data of a device was observed"),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+			now := time.Now()
+
+			for deviceID, ds := range status.get().Devices {
+				for output, observedAt := range ds.LastObserved {
+					o.Observe(now.Sub(observedAt).Seconds(), metric.WithAttributes(
+						attribute.String("device_id", strconv.Itoa(deviceID)),
+						attribute.String("output", output),
+					))
+				}
+			}
+
+			return nil
+		}),
+	)
+}
+
+func (s *scheduler) countDevices(ctx context.Context, outcome string, n int) {
+	if n > 0 {
+		s.devices.Add(ctx, int64(n), metric.WithAttributes(attribute.String("outcome", outcome)))
+	}
+}
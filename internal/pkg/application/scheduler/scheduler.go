@@ -0,0 +1,619 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"github.com/diwise/integration-acoem/internal/pkg/application/checkpoint"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var tracer = otel.Tracer("integration-acoem/scheduler")
+
+type Scheduler interface {
+	// RunOnce polls every device once and delivers the retrieved data to the sinks
+	RunOnce(ctx context.Context) (Result, error)
+	// Run polls repeatedly, aligned to the configured interval, until ctx is cancelled
+	Run(ctx context.Context) error
+	// Backfill delivers all historical data within [from, to) for the given devices, or
+	// for every device if no device IDs are given
+	Backfill(ctx context.Context, from, to time.Time, deviceIDs []int) (Result, error)
+	// Status returns the outcome of the most recent poll and of each processed device
+	Status() Status
+}
+
+// Result summarises the outcome of processing a set of devices
+type Result struct {
+	Fetched   int // the number of devices retrieved from Acoem that should be processed
+	Delivered int
+	Failed    int
+	Skipped   int // devices that were not processed, due to a shutdown, or that had no data or sensors
+}
+
+var (
+	// ErrAllDevicesFailed is returned when devices were found but none of them could be processed
+	ErrAllDevicesFailed = errors.New("all devices failed")
+	// ErrSomeDevicesFailed is returned when some, but not all, of the devices could not be processed
+	ErrSomeDevicesFailed = errors.New("some devices failed")
+
+	// errNoSensors is returned for a device without active sensors of the configured data type
+	errNoSensors = errors.New("no active sensors of the configured data type")
+)
+
+type scheduler struct {
+	app        application.IntegrationAcoem
+	sinks      []application.Sink
+	processors []application.Processor
+	interval   time.Duration
+	delay      time.Duration
+	chunk      time.Duration
+
+	checkpoints checkpoint.Store
+	maxCatchUp  time.Duration
+
+	concurrency   int
+	deviceTimeout time.Duration
+
+	status  *statusTracker
+	devices metric.Int64Counter
+}
+
+type Option func(*scheduler)
+
+// WithInterval sets the polling interval. Polls are aligned to multiples of the
+// interval, so it should match the averaging period requested from Acoem.
+func WithInterval(interval time.Duration) Option {
+	return func(s *scheduler) {
+		s.interval = interval
+	}
+}
+
+// WithDelay sets how long after each interval boundary a poll is started, giving
+// Acoem time to calculate the average for the period that just ended.
+func WithDelay(delay time.Duration) Option {
+	return func(s *scheduler) {
+		s.delay = delay
+	}
+}
+
+// WithChunkSize sets the length of the time range requested from Acoem in each
+// call when backfilling historical data or catching up since a checkpoint
+func WithChunkSize(chunk time.Duration) Option {
+	return func(s *scheduler) {
+		s.chunk = chunk
+	}
+}
+
+// WithCheckpoints sets the store used to remember the last delivered data per device.
+// Devices with a checkpoint get all data since then, instead of only the latest record.
+func WithCheckpoints(store checkpoint.Store) Option {
+	return func(s *scheduler) {
+		s.checkpoints = store
+	}
+}
+
+// WithMaxCatchUp limits how far back in time data is retrieved for a device with an
+// old checkpoint. Anything older than that has to be recovered using Backfill.
+func WithMaxCatchUp(maxCatchUp time.Duration) Option {
+	return func(s *scheduler) {
+		s.maxCatchUp = maxCatchUp
+	}
+}
+
+// WithConcurrency sets the number of devices that are processed in parallel
+func WithConcurrency(concurrency int) Option {
+	return func(s *scheduler) {
+		s.concurrency = max(concurrency, 1)
+	}
+}
+
+// WithDeviceTimeout limits the time spent retrieving and delivering the data of a
+// single device during a poll. A timeout of zero disables the limit.
+func WithDeviceTimeout(timeout time.Duration) Option {
+	return func(s *scheduler) {
+		s.deviceTimeout = timeout
+	}
+}
+
+// WithProcessors sets the stages that retrieved data passes through, in order, before
+// it is delivered to the sinks
+func WithProcessors(processors ...application.Processor) Option {
+	return func(s *scheduler) {
+		s.processors = processors
+	}
+}
+
+// New creates a scheduler that delivers the data of every device to each of the sinks.
+// The sinks are independent of each other, with a checkpoint per sink and device, so
+// that a sink that fails does not prevent delivery to the others.
+func New(app application.IntegrationAcoem, sinks []application.Sink, opts ...Option) (Scheduler, error) {
+	s := &scheduler{
+		app:      app,
+		sinks:    sinks,
+		interval: 5 * time.Minute,
+		delay:    30 * time.Second,
+		chunk:    24 * time.Hour,
+
+		checkpoints: checkpoint.NewMemoryStore(),
+		maxCatchUp:  24 * time.Hour,
+
+		concurrency:   4,
+		deviceTimeout: 2 * time.Minute,
+
+		status:  newStatusTracker(),
+		devices: newDeviceCounter(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.chunk <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive, got %s", s.chunk)
+	}
+
+	registerDataAge(s.status)
+
+	return s, nil
+}
+
+func (s *scheduler) Run(ctx context.Context) error {
+	logger := logging.GetFromContext(ctx)
+
+	if s.interval <= 0 {
+		return fmt.Errorf("polling interval must be positive, got %s", s.interval)
+	}
+
+	for {
+		result, err := s.RunOnce(ctx)
+
+		logger.Info("run summary", "fetched", result.Fetched, "delivered", result.Delivered, "failed", result.Failed, "skipped", result.Skipped)
+
+		if err != nil {
+			// there is no point in polling again until someone has fixed the credentials, which
+			// is only known for sure if the devices could not be listed
+			if errors.Is(err, application.ErrUnauthorized) && !DevicesFailed(err) {
+				return err
+			}
+
+			logger.Error("polling cycle failed", "err", err.Error())
+		}
+
+		next := nextRun(time.Now(), s.interval, s.delay)
+		logger.Info("waiting for next polling cycle", "next_run", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("polling stopped")
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *scheduler) RunOnce(ctx context.Context) (Result, error) {
+	var err error
+
+	// requests that have already been started should be allowed to finish even if
+	// we are asked to shut down, so the work is done using a context that is never
+	// cancelled and ctx is only checked between devices
+	workCtx := context.WithoutCancel(ctx)
+
+	workCtx, span := tracer.Start(workCtx, "poll-devices")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	var devices []domain.Device
+	devices, err = s.app.GetDevices(workCtx)
+	s.status.polled(time.Now().UTC(), err)
+	if err != nil {
+		err = fmt.Errorf("failed to retrieve devices: %w", err)
+		return Result{}, err
+	}
+
+	var result Result
+	result, err = s.forEachDevice(ctx, workCtx, devices, s.deviceTimeout, s.processDevice)
+
+	return result, err
+}
+
+// DevicesFailed reports whether err is returned because some or all devices failed, rather
+// than because the run as a whole failed
+func DevicesFailed(err error) bool {
+	return errors.Is(err, ErrAllDevicesFailed) || errors.Is(err, ErrSomeDevicesFailed)
+}
+
+// forEachDevice processes the devices using a pool of workers and returns the aggregated
+// result along with the errors of all failed devices. No new devices are started after
+// ctx is cancelled, but devices in progress are processed to completion using workCtx.
+func (s *scheduler) forEachDevice(ctx, workCtx context.Context, devices []domain.Device, timeout time.Duration, process func(context.Context, domain.Device) error) (Result, error) {
+	logger := logging.GetFromContext(workCtx)
+
+	result := Result{Fetched: len(devices)}
+	errs := []error{}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	jobs := make(chan domain.Device)
+
+	for range min(s.concurrency, len(devices)) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for d := range jobs {
+				deviceCtx, cancel := workCtx, context.CancelFunc(func() {})
+				if timeout > 0 {
+					deviceCtx, cancel = context.WithTimeout(workCtx, timeout)
+				}
+
+				err := process(deviceCtx, d)
+				cancel()
+
+				s.status.processed(d.UniqueId, time.Now().UTC(), err)
+
+				mu.Lock()
+				switch {
+				case err == nil:
+					result.Delivered++
+				case errors.Is(err, application.ErrNotFound):
+					logger.Warn("no data found for device, skipping", "device_id", d.UniqueId, "err", err.Error())
+					result.Skipped++
+				case errors.Is(err, errNoSensors):
+					logger.Warn("device has no active sensors of the configured data type, skipping", "device_id", d.UniqueId)
+					result.Skipped++
+				default:
+					logger.Error("failed to process device", "device_id", d.UniqueId, "err", err.Error())
+					result.Failed++
+					errs = append(errs, fmt.Errorf("device %d: %w", d.UniqueId, err))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, d := range devices {
+		// checked first since select picks at random when a worker is also ready
+		if ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case jobs <- d:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	notStarted := result.Fetched - result.Delivered - result.Failed - result.Skipped
+	if notStarted > 0 {
+		logger.Info("run interrupted, skipped remaining devices", "skipped", notStarted)
+		result.Skipped += notStarted
+	}
+
+	s.countDevices(workCtx, "delivered", result.Delivered)
+	s.countDevices(workCtx, "failed", result.Failed)
+	s.countDevices(workCtx, "skipped", result.Skipped)
+
+	switch {
+	case result.Failed > 0 && result.Failed == result.Fetched:
+		errs = append([]error{ErrAllDevicesFailed}, errs...)
+	case result.Failed > 0:
+		errs = append([]error{ErrSomeDevicesFailed}, errs...)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+func (s *scheduler) processDevice(ctx context.Context, d domain.Device) error {
+	logger := logging.GetFromContext(ctx)
+
+	sensorLabels, err := s.app.GetSensorLabels(ctx, d.UniqueId)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve sensor labels: %w", err)
+	}
+
+	if sensorLabels == "" {
+		return errNoSensors
+	}
+
+	checkpoint, found, err := s.oldestCheckpoint(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		now := time.Now().UTC()
+
+		from := checkpoint
+		if oldest := now.Add(-s.maxCatchUp); from.Before(oldest) {
+			logger.Warn("last delivered data is older than the maximum catch up period, use backfill to recover older data",
+				"device_id", d.UniqueId, "checkpoint", checkpoint.Format(time.RFC3339), "from", oldest.Format(time.RFC3339))
+			from = oldest
+		}
+
+		logger.Info("retrieving data since last checkpoint", "sensor_labels", sensorLabels, "device_id", d.UniqueId, "from", from.Format(time.RFC3339))
+
+		// the end of the range is moved past now to include a record that is timestamped exactly now
+		return s.deliverRange(ctx, ctx, d, sensorLabels, from, now.Add(time.Second), true)
+	}
+
+	logger.Info("retrieving data", "sensor_labels", sensorLabels, "device_id", d.UniqueId)
+
+	data, err := s.app.GetDeviceData(ctx, d.UniqueId, sensorLabels)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve sensor data: %w", err)
+	}
+
+	records, err := parseRecords(s.process(ctx, d, data))
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(s.deliver(ctx, d, records, true, s.sinks)...)
+}
+
+// oldestCheckpoint returns the oldest checkpoint of the device among the sinks, so that data
+// is retrieved from the point where the sink that is furthest behind left off. Sinks
+// without a checkpoint, such as a newly added sink, receive all data since then. If no
+// sink has a checkpoint, found is false.
+func (s *scheduler) oldestCheckpoint(ctx context.Context, d domain.Device) (oldest time.Time, found bool, err error) {
+	for _, sink := range s.sinks {
+		checkpoint, ok, err := s.checkpoints.Get(ctx, d.UniqueId, sink.Name())
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("failed to retrieve checkpoint for %s: %w", sink.Name(), err)
+		}
+
+		if ok && (!found || checkpoint.Before(oldest)) {
+			oldest, found = checkpoint, true
+		}
+	}
+
+	return oldest, found, nil
+}
+
+// deliverRange retrieves the data within [from, to) in chunks and delivers it. Cancelling
+// ctx stops the walk between chunks while workCtx is used for the requests themselves.
+// A sink that fails is left out of the remaining chunks, since delivering later data
+// would advance its checkpoint past the data it failed to receive.
+func (s *scheduler) deliverRange(ctx, workCtx context.Context, d domain.Device, sensorLabels string, from, to time.Time, skipDelivered bool) error {
+	sinks := s.sinks
+	errs := []error{}
+
+	for start := from; start.Before(to) && len(sinks) > 0; start = start.Add(s.chunk) {
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("interrupted at %s", start.Format(time.RFC3339)))
+			break
+		}
+
+		end := start.Add(s.chunk)
+		if end.After(to) {
+			end = to
+		}
+
+		data, err := s.app.GetDeviceDataRange(workCtx, d.UniqueId, sensorLabels, start, end)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to retrieve sensor data between %s and %s: %w", start.Format(time.RFC3339), end.Format(time.RFC3339), err))
+			break
+		}
+
+		records, err := recordsWithin(s.process(workCtx, d, data), start, end)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		sinkErrs := s.deliver(workCtx, d, records, skipDelivered, sinks)
+
+		remaining := make([]application.Sink, 0, len(sinks))
+		for idx, sink := range sinks {
+			if sinkErrs[idx] != nil {
+				errs = append(errs, sinkErrs[idx])
+				continue
+			}
+			remaining = append(remaining, sink)
+		}
+		sinks = remaining
+	}
+
+	return errors.Join(errs...)
+}
+
+// process passes the data of a device through each of the processors in turn
+func (s *scheduler) process(ctx context.Context, d domain.Device, data []domain.DeviceData) []domain.DeviceData {
+	for _, p := range s.processors {
+		data = p.Process(ctx, d, data)
+	}
+	return data
+}
+
+// deliver sends the records to each of the sinks in parallel and returns the error of
+// each sink, in the same order as the sinks
+func (s *scheduler) deliver(ctx context.Context, d domain.Device, records []record, skipDelivered bool, sinks []application.Sink) []error {
+	errs := make([]error, len(sinks))
+
+	var wg sync.WaitGroup
+
+	for idx, sink := range sinks {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs[idx] = s.deliverTo(ctx, sink, d, records, skipDelivered)
+		}()
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// deliverTo sends the records to the sink one at a time, so that each value is published
+// with the timestamp it was observed at, and advances the checkpoint of the sink after
+// each successful delivery. If skipDelivered is set, records that are not newer than the
+// checkpoint are assumed to already have been delivered and are skipped.
+func (s *scheduler) deliverTo(ctx context.Context, sink application.Sink, d domain.Device, records []record, skipDelivered bool) error {
+	checkpoint, _, err := s.checkpoints.Get(ctx, d.UniqueId, sink.Name())
+	if err != nil {
+		return fmt.Errorf("failed to retrieve checkpoint for %s: %w", sink.Name(), err)
+	}
+
+	for _, r := range records {
+		if skipDelivered && !r.observedAt.After(checkpoint) {
+			continue
+		}
+
+		err = sink.Deliver(ctx, d, []domain.DeviceData{r.data})
+		if err != nil {
+			return fmt.Errorf("failed to deliver data observed at %s to %s: %w", r.data.Timestamp.Timestamp, sink.Name(), err)
+		}
+
+		s.status.delivered(d.UniqueId, sink.Name(), r.observedAt)
+
+		if r.observedAt.After(checkpoint) {
+			err = s.checkpoints.Set(ctx, d.UniqueId, sink.Name(), r.observedAt)
+			if err != nil {
+				return fmt.Errorf("failed to store checkpoint for %s: %w", sink.Name(), err)
+			}
+			checkpoint = r.observedAt
+		}
+	}
+
+	return nil
+}
+
+func (s *scheduler) Status() Status {
+	return s.status.get()
+}
+
+func (s *scheduler) Backfill(ctx context.Context, from, to time.Time, deviceIDs []int) (Result, error) {
+	var err error
+
+	if !from.Before(to) {
+		return Result{}, fmt.Errorf("backfill start %s must be before end %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	workCtx := context.WithoutCancel(ctx)
+
+	workCtx, span := tracer.Start(workCtx, "backfill-devices")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	var devices []domain.Device
+	devices, err = s.app.GetDevices(workCtx)
+	if err != nil {
+		err = fmt.Errorf("failed to retrieve devices: %w", err)
+		return Result{}, err
+	}
+
+	devices, err = selectDevices(devices, deviceIDs)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// a backfill may take a long time and is stopped between chunks on shutdown,
+	// so no per device timeout is applied
+	var result Result
+	result, err = s.forEachDevice(ctx, workCtx, devices, 0, func(deviceCtx context.Context, d domain.Device) error {
+		return s.backfillDevice(ctx, deviceCtx, d, from, to)
+	})
+
+	return result, err
+}
+
+func (s *scheduler) backfillDevice(ctx, workCtx context.Context, d domain.Device, from, to time.Time) error {
+	logger := logging.GetFromContext(workCtx)
+
+	sensorLabels, err := s.app.GetSensorLabels(workCtx, d.UniqueId)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve sensor labels: %w", err)
+	}
+
+	if sensorLabels == "" {
+		return errNoSensors
+	}
+
+	logger.Info("retrieving historical data", "device_id", d.UniqueId, "from", from.Format(time.RFC3339), "to", to.Format(time.RFC3339))
+
+	return s.deliverRange(ctx, workCtx, d, sensorLabels, from, to, false)
+}
+
+// selectDevices returns the devices matching the given IDs, or all devices if no IDs are given
+func selectDevices(devices []domain.Device, deviceIDs []int) ([]domain.Device, error) {
+	if len(deviceIDs) == 0 {
+		return devices, nil
+	}
+
+	selected := make([]domain.Device, 0, len(deviceIDs))
+
+	for _, id := range deviceIDs {
+		idx := slices.IndexFunc(devices, func(d domain.Device) bool { return d.UniqueId == id })
+		if idx < 0 {
+			return nil, fmt.Errorf("device %d not found", id)
+		}
+		selected = append(selected, devices[idx])
+	}
+
+	return selected, nil
+}
+
+type record struct {
+	observedAt time.Time
+	data       domain.DeviceData
+}
+
+// parseRecords returns the device data sorted by the time it was observed at
+func parseRecords(data []domain.DeviceData) ([]record, error) {
+	records := make([]record, 0, len(data))
+
+	for _, d := range data {
+		observedAt, err := time.Parse(time.RFC3339, d.Timestamp.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse timestamp %q: %w", d.Timestamp.Timestamp, err)
+		}
+
+		records = append(records, record{observedAt: observedAt, data: d})
+	}
+
+	slices.SortStableFunc(records, func(a, b record) int { return a.observedAt.Compare(b.observedAt) })
+
+	return records, nil
+}
+
+// recordsWithin returns the records observed within [from, to), sorted by timestamp
+func recordsWithin(data []domain.DeviceData, from, to time.Time) ([]record, error) {
+	records, err := parseRecords(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(records, func(r record) bool {
+		return r.observedAt.Before(from) || !r.observedAt.Before(to)
+	}), nil
+}
+
+// nextRun returns the first point in time after now that lies delay past a
+// multiple of interval
+func nextRun(now time.Time, interval, delay time.Duration) time.Time {
+	next := now.Truncate(interval).Add(delay)
+	for !next.After(now) {
+		next = next.Add(interval)
+	}
+	return next
+}
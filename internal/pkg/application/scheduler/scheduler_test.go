@@ -0,0 +1,455 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-acoem/domain"
+	"github.com/diwise/integration-acoem/internal/pkg/application"
+	"github.com/diwise/integration-acoem/internal/pkg/application/checkpoint"
+	"github.com/matryer/is"
+)
+
+func TestNextRunIsAlignedToIntervalBoundary(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2024, 5, 1, 10, 7, 12, 0, time.UTC)
+
+	next := nextRun(now, 5*time.Minute, 30*time.Second)
+	is.Equal(time.Date(2024, 5, 1, 10, 10, 30, 0, time.UTC), next)
+}
+
+func TestNextRunWithinDelayOfBoundaryWaitsForDelay(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2024, 5, 1, 10, 10, 10, 0, time.UTC)
+
+	next := nextRun(now, 5*time.Minute, 30*time.Second)
+	is.Equal(time.Date(2024, 5, 1, 10, 10, 30, 0, time.UTC), next)
+}
+
+func TestRunOnceDeliversDataForEachDevice(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1, DeviceName: "one"}, {UniqueId: 2, DeviceName: "two"}},
+	}
+
+	var mu sync.Mutex
+	delivered := []int{}
+	sink := &sinkMock{
+		name: "test",
+		deliver: func(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+			mu.Lock()
+			defer mu.Unlock()
+			delivered = append(delivered, d.UniqueId)
+			return nil
+		},
+	}
+
+	result, err := newScheduler(is, app, []application.Sink{sink}).RunOnce(context.Background())
+	is.NoErr(err)
+	is.Equal(Result{Fetched: 2, Delivered: 2}, result)
+
+	slices.Sort(delivered)
+	is.Equal([]int{1, 2}, delivered)
+}
+
+func TestRunOnceStopsBetweenDevicesWhenCancelled(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}, {UniqueId: 2}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	delivered := []int{}
+	sink := &sinkMock{
+		name: "test",
+		deliver: func(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+			delivered = append(delivered, d.UniqueId)
+			cancel()
+			return ctx.Err()
+		},
+	}
+
+	result, err := newScheduler(is, app, []application.Sink{sink}, WithConcurrency(1)).RunOnce(ctx)
+	is.NoErr(err)
+	is.Equal([]int{1}, delivered) // in-flight delivery should finish, the next device should be skipped
+	is.Equal(Result{Fetched: 2, Delivered: 1, Skipped: 1}, result)
+}
+
+func TestRunOnceAggregatesDeviceErrorsAndAppliesTimeout(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}, {UniqueId: 2}, {UniqueId: 3}},
+	}
+
+	sink := &sinkMock{
+		name: "test",
+		deliver: func(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+			if d.UniqueId == 2 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		},
+	}
+
+	result, err := newScheduler(is, app, []application.Sink{sink}, WithDeviceTimeout(10*time.Millisecond)).RunOnce(context.Background())
+	is.True(errors.Is(err, context.DeadlineExceeded))
+	is.Equal(Result{Fetched: 3, Delivered: 2, Failed: 1}, result)
+}
+
+func TestRunOnceResumesFromCheckpoint(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now().UTC().Truncate(5 * time.Minute)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}},
+		history: []domain.DeviceData{
+			newDeviceData(now.Add(-10 * time.Minute).Format(time.RFC3339)),
+			newDeviceData(now.Add(-5 * time.Minute).Format(time.RFC3339)),
+			newDeviceData(now.Format(time.RFC3339)),
+		},
+	}
+
+	store := checkpoint.NewMemoryStore()
+	is.NoErr(store.Set(context.Background(), 1, "test", now.Add(-10*time.Minute)))
+
+	observed := []string{}
+	sink := &sinkMock{
+		name: "test",
+		deliver: func(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+			observed = append(observed, data[0].Timestamp.Timestamp)
+			return nil
+		},
+	}
+
+	_, err := newScheduler(is, app, []application.Sink{sink}, WithCheckpoints(store)).RunOnce(context.Background())
+	is.NoErr(err)
+
+	is.Equal(1, len(app.ranges))
+	is.Equal([]string{now.Add(-5 * time.Minute).Format(time.RFC3339), now.Format(time.RFC3339)}, observed)
+
+	cp, ok, err := store.Get(context.Background(), 1, "test")
+	is.NoErr(err)
+	is.True(ok)
+	is.True(cp.Equal(now))
+}
+
+func TestRunOnceDeliversToSinksIndependently(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now().UTC().Truncate(5 * time.Minute)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}},
+		history: []domain.DeviceData{
+			newDeviceData(now.Add(-10 * time.Minute).Format(time.RFC3339)),
+			newDeviceData(now.Add(-5 * time.Minute).Format(time.RFC3339)),
+			newDeviceData(now.Format(time.RFC3339)),
+		},
+	}
+
+	store := checkpoint.NewMemoryStore()
+	is.NoErr(store.Set(context.Background(), 1, "working", now.Add(-10*time.Minute)))
+
+	delivered := []string{}
+	working := &sinkMock{
+		name: "working",
+		deliver: func(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+			delivered = append(delivered, data[0].Timestamp.Timestamp)
+			return nil
+		},
+	}
+
+	attempts := 0
+	failing := &sinkMock{
+		name: "failing",
+		deliver: func(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+			attempts++
+			return errors.New("unavailable")
+		},
+	}
+
+	result, err := newScheduler(is, app, []application.Sink{working, failing}, WithCheckpoints(store), WithChunkSize(5*time.Minute)).RunOnce(context.Background())
+	is.True(err != nil)
+	is.Equal(Result{Fetched: 1, Failed: 1}, result)
+
+	// the failing sink has no checkpoint, so it should get everything since the oldest
+	// checkpoint, but be left out of the remaining chunks after its first failure
+	is.Equal(1, attempts)
+	is.Equal([]string{now.Add(-5 * time.Minute).Format(time.RFC3339), now.Format(time.RFC3339)}, delivered)
+
+	cp, ok, err := store.Get(context.Background(), 1, "working")
+	is.NoErr(err)
+	is.True(ok)
+	is.True(cp.Equal(now))
+
+	_, ok, err = store.Get(context.Background(), 1, "failing")
+	is.NoErr(err)
+	is.True(!ok)
+}
+
+func TestBackfillWalksRangeInChunksAndDeliversEachRecord(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}, {UniqueId: 2}},
+		history: []domain.DeviceData{
+			newDeviceData("2024-05-01T12:05:00+00:00"),
+			newDeviceData("2024-05-01T00:05:00+00:00"),
+			newDeviceData("2024-05-02T00:00:00+00:00"),
+		},
+	}
+
+	observed := []string{}
+	sink := &sinkMock{
+		name: "test",
+		deliver: func(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+			is.Equal(2, d.UniqueId)
+			is.Equal(1, len(data))
+			observed = append(observed, data[0].Timestamp.Timestamp)
+			return nil
+		},
+	}
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+
+	_, err := newScheduler(is, app, []application.Sink{sink}, WithChunkSize(12*time.Hour)).Backfill(context.Background(), from, to, []int{2})
+	is.NoErr(err)
+
+	is.Equal(2, len(app.ranges))
+	is.Equal(from.Add(12*time.Hour), app.ranges[0][1])
+
+	// the mock returns every record for each chunk, only those within the chunk should be delivered
+	is.Equal([]string{"2024-05-01T00:05:00+00:00", "2024-05-01T12:05:00+00:00"}, observed)
+}
+
+func TestBackfillFailsForUnknownDevice(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{devices: []domain.Device{{UniqueId: 1}}}
+	sink := &sinkMock{name: "test", deliver: func(context.Context, domain.Device, []domain.DeviceData) error { return nil }}
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	_, err := newScheduler(is, app, []application.Sink{sink}).Backfill(context.Background(), from, from.Add(time.Hour), []int{3})
+	is.True(err != nil)
+}
+
+func newDeviceData(timestamp string) domain.DeviceData {
+	d := domain.DeviceData{}
+	d.Timestamp.Timestamp = timestamp
+	return d
+}
+
+func TestRunOnceClassifiesDeviceErrors(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}, {UniqueId: 2}, {UniqueId: 3}},
+		errs: map[int]error{
+			1: application.ErrNotFound,
+			2: errors.New("something failed"),
+		},
+	}
+
+	sink := &sinkMock{name: "test", deliver: func(context.Context, domain.Device, []domain.DeviceData) error { return nil }}
+
+	result, err := newScheduler(is, app, []application.Sink{sink}).RunOnce(context.Background())
+	is.True(err != nil)
+	is.True(!errors.Is(err, ErrAllDevicesFailed))
+	is.True(errors.Is(err, ErrSomeDevicesFailed))
+	is.Equal(Result{Fetched: 3, Delivered: 1, Failed: 1, Skipped: 1}, result)
+}
+
+func TestRunOnceSkipsDevicesWithoutSensors(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}, {UniqueId: 2}},
+		labels:  map[int]string{1: ""},
+	}
+
+	sink := &sinkMock{name: "test", deliver: func(context.Context, domain.Device, []domain.DeviceData) error { return nil }}
+
+	result, err := newScheduler(is, app, []application.Sink{sink}).RunOnce(context.Background())
+	is.NoErr(err)
+	is.Equal(Result{Fetched: 2, Delivered: 1, Skipped: 1}, result)
+}
+
+func TestRunOnceReportsWhenAllDevicesFailed(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}, {UniqueId: 2}},
+	}
+
+	sink := &sinkMock{name: "test", deliver: func(context.Context, domain.Device, []domain.DeviceData) error { return errors.New("unavailable") }}
+
+	result, err := newScheduler(is, app, []application.Sink{sink}).RunOnce(context.Background())
+	is.True(errors.Is(err, ErrAllDevicesFailed))
+	is.Equal(Result{Fetched: 2, Failed: 2}, result)
+}
+
+func TestRunStopsWhenUnauthorized(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{devicesErr: application.ErrUnauthorized}
+
+	sink := &sinkMock{name: "test", deliver: func(context.Context, domain.Device, []domain.DeviceData) error { return nil }}
+
+	err := newScheduler(is, app, []application.Sink{sink}).Run(context.Background())
+	is.True(errors.Is(err, application.ErrUnauthorized))
+}
+
+func TestRunOnceTreatsAnUnauthorizedDeviceAsAFailedDevice(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}, {UniqueId: 2}, {UniqueId: 3}},
+		errs:    map[int]error{1: application.ErrUnauthorized},
+	}
+
+	sink := &sinkMock{name: "test", deliver: func(context.Context, domain.Device, []domain.DeviceData) error { return nil }}
+
+	result, err := newScheduler(is, app, []application.Sink{sink}, WithConcurrency(1)).RunOnce(context.Background())
+	is.True(errors.Is(err, ErrSomeDevicesFailed))
+	is.Equal(Result{Fetched: 3, Delivered: 2, Failed: 1}, result)
+}
+
+func TestStatusReportsPollAndDeviceOutcomes(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{
+		devices: []domain.Device{{UniqueId: 1}, {UniqueId: 2}},
+		errs:    map[int]error{2: errors.New("something failed")},
+	}
+
+	sink := &sinkMock{name: "test", deliver: func(context.Context, domain.Device, []domain.DeviceData) error { return nil }}
+
+	s := newScheduler(is, app, []application.Sink{sink})
+	is.True(s.Status().AcoemReachable() != nil) // should not be reachable before the first poll
+
+	_, err := s.RunOnce(context.Background())
+	is.True(err != nil)
+
+	status := s.Status()
+	is.NoErr(status.AcoemReachable())
+	is.Equal(2, len(status.Devices))
+
+	is.True(status.Devices[1].LastSuccess != nil)
+	is.Equal(time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC), status.Devices[1].LastObserved["test"].UTC())
+	is.Equal("", status.Devices[1].LastError)
+
+	is.True(status.Devices[2].LastSuccess == nil)
+	is.True(status.Devices[2].LastErrorAt != nil)
+	is.Equal("failed to retrieve sensor labels: something failed", status.Devices[2].LastError)
+}
+
+func TestRunOncePassesDataThroughProcessorsInOrder(t *testing.T) {
+	is := is.New(t)
+
+	app := &appMock{devices: []domain.Device{{UniqueId: 1}}}
+
+	var delivered []domain.DeviceData
+	sink := &sinkMock{name: "test", deliver: func(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+		delivered = data
+		return nil
+	}}
+
+	addChannel := func(name string) application.Processor {
+		return processorFunc(func(ctx context.Context, d domain.Device, data []domain.DeviceData) []domain.DeviceData {
+			result := slices.Clone(data)
+			for idx := range result {
+				result[idx].Channels = append(slices.Clone(result[idx].Channels), domain.Channel{SensorName: name})
+			}
+			return result
+		})
+	}
+
+	_, err := newScheduler(is, app, []application.Sink{sink}, WithProcessors(addChannel("first"), addChannel("second"))).RunOnce(context.Background())
+	is.NoErr(err)
+
+	is.Equal(1, len(delivered))
+	is.Equal([]domain.Channel{{SensorName: "first"}, {SensorName: "second"}}, delivered[0].Channels)
+}
+
+type processorFunc func(ctx context.Context, d domain.Device, data []domain.DeviceData) []domain.DeviceData
+
+func (f processorFunc) Process(ctx context.Context, d domain.Device, data []domain.DeviceData) []domain.DeviceData {
+	return f(ctx, d, data)
+}
+
+type sinkMock struct {
+	name    string
+	deliver func(ctx context.Context, d domain.Device, data []domain.DeviceData) error
+}
+
+func (m *sinkMock) Name() string {
+	return m.name
+}
+
+func (m *sinkMock) Deliver(ctx context.Context, d domain.Device, data []domain.DeviceData) error {
+	return m.deliver(ctx, d, data)
+}
+
+func (m *sinkMock) Close() error {
+	return nil
+}
+
+type appMock struct {
+	errs       map[int]error
+	labels     map[int]string
+	devices    []domain.Device
+	devicesErr error
+	history    []domain.DeviceData
+	ranges     [][2]time.Time
+}
+
+func (m *appMock) GetDevices(ctx context.Context) ([]domain.Device, error) {
+	return m.devices, m.devicesErr
+}
+
+func (m *appMock) GetDeviceData(ctx context.Context, uniqueId int, sensorLabels string) ([]domain.DeviceData, error) {
+	return []domain.DeviceData{newDeviceData("2024-05-01T10:05:00+00:00")}, nil
+}
+
+func (m *appMock) GetDeviceDataRange(ctx context.Context, uniqueId int, sensorLabels string, from, to time.Time) ([]domain.DeviceData, error) {
+	m.ranges = append(m.ranges, [2]time.Time{from, to})
+	return m.history, nil
+}
+
+func (m *appMock) GetSensorLabels(ctx context.Context, deviceID int) (string, error) {
+	if err, ok := m.errs[deviceID]; ok {
+		return "", err
+	}
+	if labels, ok := m.labels[deviceID]; ok {
+		return labels, nil
+	}
+	return "PM10", nil
+}
+
+func TestNewRejectsInvalidChunkSize(t *testing.T) {
+	is := is.New(t)
+
+	for _, chunk := range []time.Duration{0, -time.Hour} {
+		_, err := New(&appMock{}, nil, WithChunkSize(chunk))
+		is.True(err != nil)
+	}
+}
+
+func newScheduler(is *is.I, app application.IntegrationAcoem, sinks []application.Sink, opts ...Option) Scheduler {
+	s, err := New(app, sinks, opts...)
+	is.NoErr(err)
+	return s
+}
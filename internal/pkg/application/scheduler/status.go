@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+)
+
+// Status describes the state of the integration after the most recent polls
+type Status struct {
+	LastPoll      *time.Time           `json:"lastPoll,omitempty"`
+	LastPollError string               `json:"lastPollError,omitempty"`
+	Devices       map[int]DeviceStatus `json:"devices"`
+}
+
+// DeviceStatus describes the outcome of the most recent attempts to process a device
+type DeviceStatus struct {
+	LastSuccess  *time.Time           `json:"lastSuccess,omitempty"`
+	LastObserved map[string]time.Time `json:"lastObserved,omitempty"` // the observation time of the latest data delivered to each output
+	LastError    string               `json:"lastError,omitempty"`
+	LastErrorAt  *time.Time           `json:"lastErrorAt,omitempty"`
+}
+
+// AcoemReachable returns an error unless the most recent poll managed to retrieve
+// the list of devices from Acoem
+func (s Status) AcoemReachable() error {
+	if s.LastPoll == nil {
+		return errors.New("acoem has not been polled yet")
+	}
+
+	if s.LastPollError != "" {
+		return errors.New(s.LastPollError)
+	}
+
+	return nil
+}
+
+// statusTracker records the outcome of polls and devices as they are processed
+type statusTracker struct {
+	mu     sync.Mutex
+	status Status
+}
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{
+		status: Status{Devices: map[int]DeviceStatus{}},
+	}
+}
+
+func (t *statusTracker) polled(at time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.LastPoll = &at
+	t.status.LastPollError = ""
+
+	if err != nil {
+		t.status.LastPollError = err.Error()
+	}
+}
+
+func (t *statusTracker) processed(deviceID int, at time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ds := t.status.Devices[deviceID]
+
+	if err != nil {
+		ds.LastError = err.Error()
+		ds.LastErrorAt = &at
+	} else {
+		ds.LastSuccess = &at
+	}
+
+	t.status.Devices[deviceID] = ds
+}
+
+func (t *statusTracker) delivered(deviceID int, output string, observedAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ds := t.status.Devices[deviceID]
+
+	if ds.LastObserved == nil {
+		ds.LastObserved = map[string]time.Time{}
+	}
+
+	if last, ok := ds.LastObserved[output]; !ok || observedAt.After(last) {
+		// the map is replaced rather than modified, since copies returned by get share it
+		ds.LastObserved = maps.Clone(ds.LastObserved)
+		ds.LastObserved[output] = observedAt
+	}
+
+	t.status.Devices[deviceID] = ds
+}
+
+// get returns a copy of the current status that is safe to use concurrently with the tracker
+func (t *statusTracker) get() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status
+	status.Devices = maps.Clone(t.status.Devices)
+
+	return status
+}
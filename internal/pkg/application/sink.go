@@ -0,0 +1,18 @@
+package application
+
+import (
+	"context"
+
+	"github.com/diwise/integration-acoem/domain"
+)
+
+// Sink is a destination that device data retrieved from Acoem is delivered to
+type Sink interface {
+	// Name identifies the sink in logs, metrics and checkpoints, and must be unique
+	Name() string
+	// Deliver sends the data of a device to the sink and returns an error unless all
+	// of it was delivered
+	Deliver(ctx context.Context, device domain.Device, data []domain.DeviceData) error
+	// Close releases any resources held by the sink
+	Close() error
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+type CleanupFunc func()
+
+// Init installs a global meter provider that exposes all metrics to the default prometheus
+// registry, served by the metrics endpoint, and that also pushes them to the OTLP endpoint
+// given by OTEL_EXPORTER_OTLP_ENDPOINT, if any.
+//
+// It replaces the meter provider installed by o11y.Init, which only supports OTLP, and must
+// therefore be called after o11y.Init but before any instruments are created.
+func Init(ctx context.Context, serviceName, serviceVersion string) (CleanupFunc, error) {
+	promExporter, err := prometheus.New()
+	if err != nil {
+		return func() {}, fmt.Errorf("failed to create prometheus exporter: %w", err)
+	}
+
+	opts := []metric.Option{
+		metric.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String(serviceVersion),
+		)),
+		metric.WithReader(promExporter),
+	}
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		otlpExporter, err := otlpmetrichttp.New(ctx)
+		if err != nil {
+			return func() {}, fmt.Errorf("failed to create otlp exporter: %w", err)
+		}
+
+		opts = append(opts, metric.WithReader(
+			metric.NewPeriodicReader(otlpExporter, metric.WithInterval(10*time.Second)),
+		))
+	}
+
+	meterProvider := metric.NewMeterProvider(opts...)
+
+	otel.SetMeterProvider(meterProvider)
+
+	return func() {
+		// shutting down flushes any metrics that have not been pushed yet
+		err := meterProvider.Shutdown(context.WithoutCancel(ctx))
+		if err != nil {
+			logging.GetFromContext(ctx).Error("failed to shutdown meter provider", "err", err.Error())
+		}
+	}, nil
+}
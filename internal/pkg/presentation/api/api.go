@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/diwise/integration-acoem/internal/pkg/application/scheduler"
+	"github.com/diwise/service-chassis/pkg/infrastructure/net/http/handlers"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/metrics"
+)
+
+type StatusFunc func() scheduler.Status
+
+// RegisterHandlers adds the health, readiness, status and metrics endpoints to mux. The
+// service is ready when all probes succeed.
+func RegisterHandlers(ctx context.Context, mux *http.ServeMux, status StatusFunc, probes map[string]handlers.ServiceProber) {
+	mux.HandleFunc("GET /health", handlers.NewLivenessHandler(ctx, func() error { return nil }))
+	mux.HandleFunc("GET /ready", handlers.NewReadinessHandler(ctx, probes))
+	mux.HandleFunc("GET /status", NewStatusHandler(ctx, status))
+
+	metrics.AddHandlers(mux)
+}
+
+func NewStatusHandler(ctx context.Context, status StatusFunc) http.HandlerFunc {
+	logger := logging.GetFromContext(ctx)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := json.Marshal(status())
+		if err != nil {
+			logger.Error("failed to marshal status", "err", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-acoem/internal/pkg/application/scheduler"
+	"github.com/diwise/service-chassis/pkg/infrastructure/net/http/handlers"
+	"github.com/matryer/is"
+)
+
+func TestStatusReturnsDeviceStatusAsJSON(t *testing.T) {
+	is := is.New(t)
+
+	lastPoll := time.Date(2024, 5, 1, 10, 5, 30, 0, time.UTC)
+	status := func() scheduler.Status {
+		return scheduler.Status{
+			LastPoll: &lastPoll,
+			Devices: map[int]scheduler.DeviceStatus{
+				888100: {LastError: "failed to retrieve sensor labels", LastErrorAt: &lastPoll},
+			},
+		}
+	}
+
+	server := newTestServer(status, nil)
+	defer server.Close()
+
+	resp, body := get(is, server.URL+"/status")
+	is.Equal(http.StatusOK, resp.StatusCode)
+	is.Equal("application/json", resp.Header.Get("Content-Type"))
+	is.Equal(`{"lastPoll":"2024-05-01T10:05:30Z","devices":{"888100":{"lastError":"failed to retrieve sensor labels","lastErrorAt":"2024-05-01T10:05:30Z"}}}`, body)
+}
+
+func TestReadyFailsWhenAProbeFails(t *testing.T) {
+	is := is.New(t)
+
+	var acoemErr error
+
+	probes := map[string]handlers.ServiceProber{
+		"acoem": func(context.Context) (string, error) { return "", acoemErr },
+	}
+
+	server := newTestServer(func() scheduler.Status { return scheduler.Status{} }, probes)
+	defer server.Close()
+
+	resp, _ := get(is, server.URL+"/ready")
+	is.Equal(http.StatusNoContent, resp.StatusCode)
+
+	acoemErr = errors.New("acoem has not been polled yet")
+
+	resp, _ = get(is, server.URL+"/ready")
+	is.Equal(http.StatusInternalServerError, resp.StatusCode)
+
+	resp, _ = get(is, server.URL+"/health")
+	is.Equal(http.StatusNoContent, resp.StatusCode) // should still be alive
+}
+
+func newTestServer(status StatusFunc, probes map[string]handlers.ServiceProber) *httptest.Server {
+	mux := http.NewServeMux()
+	RegisterHandlers(context.Background(), mux, status, probes)
+	return httptest.NewServer(mux)
+}
+
+func get(is *is.I, url string) (*http.Response, string) {
+	resp, err := http.Get(url)
+	is.NoErr(err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	is.NoErr(err)
+
+	return resp, string(body)
+}